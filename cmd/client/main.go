@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/database"
+	"github.com/user/user-server/pkg/models"
+)
+
+const usage = `Usage: client [-db PATH] COMMAND [OPTIONS]
+
+Manages credentials of backend services calling the introspection endpoint.
+
+Commands:
+  create  Create a service client and print its credentials
+  list    Print the service clients
+  delete  Delete a service client by client ID
+`
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using default values or command line flags")
+	}
+
+	dbPath := flag.String("db", getEnv("DB_PATH", "user-server.db"), "Path to SQLite database file")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Fatalf("Database connection error: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Initialize(); err != nil {
+		log.Fatalf("Database initialization error: %v", err)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "create":
+		err = createClient(db, args)
+	case "list":
+		err = listClients(db)
+	case "delete":
+		err = deleteClient(db, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s error: %v", command, err)
+	}
+}
+
+func createClient(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	name := flags.String("name", "", "Service name")
+	audience := flags.String("audience", "", "Only access tokens for this audience are reported active")
+	flags.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+
+	clientID, err := auth.GenerateInviteToken()
+	if err != nil {
+		return fmt.Errorf("client ID generation error: %w", err)
+	}
+
+	secret, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("client secret generation error: %w", err)
+	}
+
+	client := &models.ServiceClient{
+		ClientID:   clientID,
+		SecretHash: auth.HashClientSecret(secret),
+		Name:       *name,
+		Audience:   *audience,
+	}
+	if err := db.CreateServiceClient(client); err != nil {
+		return fmt.Errorf("service client save error: %w", err)
+	}
+
+	fmt.Printf("Client ID:     %s\n", client.ClientID)
+	fmt.Printf("Client secret: %s\n", secret)
+	fmt.Println("The secret is not stored and cannot be shown again")
+	return nil
+}
+
+func listClients(db *database.DB) error {
+	clients, err := db.ListServiceClients()
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		line := fmt.Sprintf("%s  %s", client.ClientID, client.Name)
+		if client.Audience != "" {
+			line += fmt.Sprintf(" (audience %s)", client.Audience)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func deleteClient(db *database.DB, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a client ID")
+	}
+
+	deleted, err := db.DeleteServiceClient(args[0])
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("client %s not found", args[0])
+	}
+
+	fmt.Printf("Deleted client: %s\n", args[0])
+	return nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/user/user-server/pkg/auth"
+)
+
+const usage = `Usage: keys [-dir DIR] COMMAND [OPTIONS]
+
+Commands:
+  init      Create a key ring with a new current key
+  generate  Generate a new key and stage it as next
+  import    Add an existing PEM private key to the key ring
+  promote   Make the next key current and retire the current one
+  retire    Remove previous keys whose grace window has passed
+  list      Print the keys in the key ring
+`
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using default values or command line flags")
+	}
+
+	dir := flag.String("dir", getEnv("KEYS_DIR", ""), "Path to signing key ring directory (default \"keys\")")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dir == "" {
+		*dir = "keys"
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch command {
+	case "init":
+		err = initKeyRing(*dir, args)
+	case "generate":
+		err = generateKey(*dir, args)
+	case "import":
+		err = importKey(*dir, args)
+	case "promote":
+		err = promoteKey(*dir, args)
+	case "retire":
+		err = retireKeys(*dir, args)
+	case "list":
+		err = listKeys(*dir)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s error: %v", command, err)
+	}
+}
+
+func initKeyRing(dir string, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	keyType := flags.String("type", "rsa", "Key type: rsa, ecdsa or ed25519")
+	bits := flags.Int("bits", 2048, "RSA key size in bits")
+	alg := flags.String("alg", "", "Signing algorithm: RS256, PS256, ES256 or EdDSA (inferred from the key type if empty)")
+	flags.Parse(args)
+
+	if _, err := auth.ReadKeyRingManifest(dir); err == nil {
+		return fmt.Errorf("key ring already exists in %s", dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	privateKey, err := auth.GenerateSigningKey(*keyType, *bits)
+	if err != nil {
+		return err
+	}
+
+	manifest := &auth.KeyRingManifest{}
+	kid, err := addKey(dir, manifest, privateKey, *alg, auth.KeyStatusCurrent)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Current key: %s\n", kid)
+	return nil
+}
+
+func generateKey(dir string, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	keyType := flags.String("type", "rsa", "Key type: rsa, ecdsa or ed25519")
+	bits := flags.Int("bits", 2048, "RSA key size in bits")
+	alg := flags.String("alg", "", "Signing algorithm: RS256, PS256, ES256 or EdDSA (inferred from the key type if empty)")
+	flags.Parse(args)
+
+	manifest, err := auth.ReadKeyRingManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	if next := manifest.WithStatus(auth.KeyStatusNext); len(next) > 0 {
+		return fmt.Errorf("key %s is already staged as next, promote it first", next[0].ID)
+	}
+
+	privateKey, err := auth.GenerateSigningKey(*keyType, *bits)
+	if err != nil {
+		return err
+	}
+
+	kid, err := addKey(dir, manifest, privateKey, *alg, auth.KeyStatusNext)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Next key: %s\n", kid)
+	return nil
+}
+
+func importKey(dir string, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	privateKeyPath := flags.String("private-key", getEnv("PRIVATE_KEY_PATH", "keys/private.pem"), "Path to PEM private key")
+	alg := flags.String("alg", getEnv("JWT_ALG", ""), "Signing algorithm: RS256, PS256, ES256 or EdDSA (inferred from the key type if empty)")
+	flags.Parse(args)
+
+	data, err := os.ReadFile(*privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("private key read error: %w", err)
+	}
+
+	privateKey, err := auth.ParsePrivateKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("private key parse error: %w", err)
+	}
+
+	// Importing into a missing key ring bootstraps it with the key as
+	// current, which is how existing single-key setups migrate.
+	status := auth.KeyStatusNext
+	manifest, err := auth.ReadKeyRingManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		manifest = &auth.KeyRingManifest{}
+		status = auth.KeyStatusCurrent
+	} else if err != nil {
+		return err
+	} else if next := manifest.WithStatus(auth.KeyStatusNext); len(next) > 0 {
+		return fmt.Errorf("key %s is already staged as next, promote it first", next[0].ID)
+	}
+
+	kid, err := addKey(dir, manifest, privateKey, *alg, status)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported key: %s (%s)\n", kid, status)
+	return nil
+}
+
+func promoteKey(dir string, args []string) error {
+	manifest, err := auth.ReadKeyRingManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var target *auth.KeyRingEntry
+	if len(args) > 0 {
+		target = manifest.Find(args[0])
+		if target == nil {
+			return fmt.Errorf("key %s not found", args[0])
+		}
+	} else {
+		next := manifest.WithStatus(auth.KeyStatusNext)
+		if len(next) == 0 {
+			return errors.New("no key is staged as next, run generate first")
+		}
+		target = next[0]
+	}
+
+	if target.Status == auth.KeyStatusCurrent {
+		return fmt.Errorf("key %s is already current", target.ID)
+	}
+
+	now := time.Now().UTC()
+	for _, current := range manifest.WithStatus(auth.KeyStatusCurrent) {
+		current.Status = auth.KeyStatusPrevious
+		current.RetiredAt = &now
+		fmt.Printf("Retired key: %s\n", current.ID)
+	}
+	target.Status = auth.KeyStatusCurrent
+	target.RetiredAt = nil
+
+	if err := auth.WriteKeyRingManifest(dir, manifest); err != nil {
+		return err
+	}
+
+	fmt.Printf("Current key: %s\n", target.ID)
+	fmt.Println("Restart the server to start signing with the new key")
+	return nil
+}
+
+func retireKeys(dir string, args []string) error {
+	flags := flag.NewFlagSet("retire", flag.ExitOnError)
+	kid := flags.String("kid", "", "Remove this previous key even if its grace window has not passed")
+	graceHours := flags.Int("grace", getEnvAsInt("KEY_GRACE", 0), "How long retired signing keys are accepted, in hours (0 means JWT_TTL)")
+	flags.Parse(args)
+
+	grace := time.Duration(*graceHours) * time.Hour
+	if grace == 0 {
+		grace = time.Duration(getEnvAsInt("JWT_TTL", 24)) * time.Hour
+	}
+
+	manifest, err := auth.ReadKeyRingManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var retired []auth.KeyRingEntry
+	for _, entry := range manifest.WithStatus(auth.KeyStatusPrevious) {
+		if *kid != "" && entry.ID != *kid {
+			continue
+		}
+		if *kid == "" && entry.RetiredAt != nil && time.Since(*entry.RetiredAt) < grace {
+			continue
+		}
+		retired = append(retired, *entry)
+	}
+
+	if *kid != "" && len(retired) == 0 {
+		return fmt.Errorf("key %s is not a previous key", *kid)
+	}
+
+	for _, entry := range retired {
+		manifest.Remove(entry.ID)
+	}
+	if err := auth.WriteKeyRingManifest(dir, manifest); err != nil {
+		return err
+	}
+
+	for _, entry := range retired {
+		if err := os.Remove(filepath.Join(dir, entry.File)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error removing key file %s: %v", entry.File, err)
+		}
+		fmt.Printf("Removed key: %s\n", entry.ID)
+	}
+	if len(retired) == 0 {
+		fmt.Println("No keys to remove")
+	}
+	return nil
+}
+
+func listKeys(dir string) error {
+	manifest, err := auth.ReadKeyRingManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range manifest.Keys {
+		line := fmt.Sprintf("%-8s  %-5s  %s  created %s", entry.Status, entry.Algorithm, entry.ID, entry.CreatedAt.Format(time.RFC3339))
+		if entry.RetiredAt != nil {
+			line += fmt.Sprintf(", retired %s", entry.RetiredAt.Format(time.RFC3339))
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func addKey(dir string, manifest *auth.KeyRingManifest, privateKey crypto.Signer, alg string, status auth.KeyStatus) (string, error) {
+	key, err := auth.NewSigningKey(privateKey, alg)
+	if err != nil {
+		return "", err
+	}
+
+	if manifest.Find(key.ID) != nil {
+		return "", fmt.Errorf("key %s is already in the key ring", key.ID)
+	}
+
+	data, err := auth.MarshalPrivateKeyPEM(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("private key marshal error: %w", err)
+	}
+
+	file := key.ID + ".pem"
+	if err := os.WriteFile(filepath.Join(dir, file), data, 0o600); err != nil {
+		return "", fmt.Errorf("private key write error: %w", err)
+	}
+
+	manifest.Keys = append(manifest.Keys, auth.KeyRingEntry{
+		ID:        key.ID,
+		File:      file,
+		Status:    status,
+		Algorithm: key.Algorithm,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err := auth.WriteKeyRingManifest(dir, manifest); err != nil {
+		return "", err
+	}
+
+	return key.ID, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
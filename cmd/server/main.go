@@ -5,13 +5,27 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+	// Profile timezones are validated against the embedded database, so
+	// they work in images without system tzdata.
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/user/user-server/pkg/auth"
 	"github.com/user/user-server/pkg/database"
 	"github.com/user/user-server/pkg/handlers"
+	"github.com/user/user-server/pkg/notify"
+)
+
+const (
+	cleanupInterval = time.Minute
+	// mailInterval is how often queued mail is retried.
+	mailInterval = 10 * time.Second
+	// mailRetention is how long delivered and failed mail is kept in the
+	// outbox, without its bodies.
+	mailRetention = 7 * 24 * time.Hour
 )
 
 func main() {
@@ -20,30 +34,139 @@ func main() {
 	}
 
 	dbPath := flag.String("db", getEnv("DB_PATH", "user-server.db"), "Path to SQLite database file")
-	privateKeyPath := flag.String("private-key", getEnv("PRIVATE_KEY_PATH", "keys/private.pem"), "Path to RSA private key")
-	publicKeyPath := flag.String("public-key", getEnv("PUBLIC_KEY_PATH", "keys/public.pem"), "Path to RSA public key")
+	privateKeyPath := flag.String("private-key", getEnv("PRIVATE_KEY_PATH", "keys/private.pem"), "Path to private key")
+	publicKeyPath := flag.String("public-key", getEnv("PUBLIC_KEY_PATH", "keys/public.pem"), "Path to public key")
+	jwtAlg := flag.String("jwt-alg", getEnv("JWT_ALG", ""), "Signing algorithm for -private-key: RS256, PS256, ES256 or EdDSA (inferred from the key type if empty)")
+	keysDir := flag.String("keys-dir", getEnv("KEYS_DIR", ""), "Path to signing key ring directory (overrides -private-key and -public-key)")
 	addr := flag.String("addr", getEnv("ADDR", ":8080"), "HTTP listen address")
+	tokenSecret := flag.String("token-secret", getEnv("TOKEN_SECRET", ""), "Server secret used to hash refresh tokens at rest (required)")
+	adminAPIKey := flag.String("admin-api-key", getEnv("ADMIN_API_KEY", ""), "API key for /api/admin endpoints, in addition to users with the admin role (key access is disabled if empty)")
 	jwtTTLHours := flag.Int("jwt-ttl", getEnvAsInt("JWT_TTL", 24), "JWT token lifetime in hours")
+	refreshTTLHours := flag.Int("refresh-ttl", getEnvAsInt("REFRESH_TTL", 720), "Refresh token lifetime in hours, extended on every refresh")
+	sessionMaxAgeHours := flag.Int("session-max-age", getEnvAsInt("SESSION_MAX_AGE", 0), "Maximum session age since login in hours (0 means no limit)")
+	sessionIdleHours := flag.Int("session-idle-timeout", getEnvAsInt("SESSION_IDLE_TIMEOUT", 0), "Session idle timeout in hours (0 means no limit beyond -refresh-ttl)")
+	jwtIssuer := flag.String("jwt-issuer", getEnv("JWT_ISSUER", ""), "Issuer (iss) of JWT tokens, required on verification when set")
+	jwtAudience := flag.String("jwt-audience", getEnv("JWT_AUDIENCE", ""), "Comma-separated audiences (aud) of JWT tokens, one of which is required on verification when set")
+	notifierKind := flag.String("notifier", getEnv("NOTIFIER", "log"), "How password reset and email verification tokens are delivered: log, file or mail")
+	notifyFile := flag.String("notify-file", getEnv("NOTIFY_FILE", "notifications.log"), "File messages are appended to with -notifier file")
+	passwordResetTTLMinutes := flag.Int("password-reset-ttl", getEnvAsInt("PASSWORD_RESET_TTL", 60), "Password reset token lifetime in minutes")
+	passwordResetURL := flag.String("password-reset-url", getEnv("PASSWORD_RESET_URL", ""), "Password reset page URL, the token is added as the token query parameter")
+	mailBackend := flag.String("mail-backend", getEnv("MAIL_BACKEND", "smtp"), "How mail is sent with -notifier mail: smtp or file")
+	mailFrom := flag.String("mail-from", getEnv("MAIL_FROM", ""), "Sender address of outgoing mail")
+	smtpHost := flag.String("smtp-host", getEnv("SMTP_HOST", ""), "SMTP server host")
+	smtpPort := flag.Int("smtp-port", getEnvAsInt("SMTP_PORT", 587), "SMTP server port")
+	smtpUsername := flag.String("smtp-username", getEnv("SMTP_USERNAME", ""), "SMTP username (no authentication if empty)")
+	smtpPassword := flag.String("smtp-password", getEnv("SMTP_PASSWORD", ""), "SMTP password")
+	mailDir := flag.String("mail-dir", getEnv("MAIL_DIR", "mail"), "Directory messages are written to with -mail-backend file")
+	mailMaxAttempts := flag.Int("mail-max-attempts", getEnvAsInt("MAIL_MAX_ATTEMPTS", 10), "How many times sending a message is attempted before giving up")
+	requireEmail := flag.Bool("require-email", getEnvAsBool("REQUIRE_EMAIL", false), "Require an email address on registration")
+	emailVerificationTTLHours := flag.Int("email-verification-ttl", getEnvAsInt("EMAIL_VERIFICATION_TTL", 24), "Email verification token lifetime in hours")
+	emailVerificationURL := flag.String("email-verification-url", getEnv("EMAIL_VERIFICATION_URL", ""), "Email verification page URL, the token is added as the token query parameter")
+	accountDeletionGraceHours := flag.Int("account-deletion-grace", getEnvAsInt("ACCOUNT_DELETION_GRACE", 168), "How long a deleted account can be restored by logging in, in hours")
+	scopes := flag.String("scopes", getEnv("SCOPES", ""), "Comma-separated scopes clients may ask for, all granted by default (any scope may be asked for if empty)")
+	enforceUserStatus := flag.Bool("enforce-user-status", getEnvAsBool("ENFORCE_USER_STATUS", false), "Check the account status on every authenticated request")
+	keyGraceHours := flag.Int("key-grace", getEnvAsInt("KEY_GRACE", 0), "How long retired signing keys are accepted, in hours (0 means the JWT token lifetime)")
 	flag.Parse()
 
+	if *tokenSecret == "" {
+		log.Fatal("Token secret is required, set TOKEN_SECRET or -token-secret")
+	}
+
 	db, err := database.New(*dbPath)
 	if err != nil {
 		log.Fatalf("Database connection error: %v", err)
 	}
 	defer db.Close()
 
+	db.SetTokenKey([]byte(*tokenSecret))
+
 	if err := db.Initialize(); err != nil {
 		log.Fatalf("Database initialization error: %v", err)
 	}
 
-	jwtManager, err := auth.NewJWTManager(*privateKeyPath, *publicKeyPath, time.Duration(*jwtTTLHours)*time.Hour)
+	hashed, err := db.HashRefreshTokens()
+	if err != nil {
+		log.Fatalf("Refresh token migration error: %v", err)
+	}
+	if hashed > 0 {
+		log.Printf("Hashed %d refresh token(s) stored in plain text", hashed)
+	}
+
+	jwtTTL := time.Duration(*jwtTTLHours) * time.Hour
+	keyGrace := time.Duration(*keyGraceHours) * time.Hour
+	if keyGrace == 0 {
+		keyGrace = jwtTTL
+	}
+
+	var keyRing *auth.KeyRing
+	if *keysDir != "" {
+		keyRing, err = auth.LoadKeyRing(*keysDir, keyGrace)
+	} else {
+		keyRing, err = auth.LoadKeyPair(*privateKeyPath, *publicKeyPath, *jwtAlg)
+	}
+	if err != nil {
+		log.Fatalf("Signing key loading error: %v", err)
+	}
+	log.Printf("Signing with %s key %s, %d key(s) accepted for verification", keyRing.Current().Algorithm, keyRing.Current().ID, len(keyRing.VerificationKeys()))
+
+	jwtManager := auth.NewJWTManager(keyRing, jwtTTL, *jwtIssuer, splitList(*jwtAudience))
+
+	allowedScopes, ok := auth.ParseScope(strings.Join(splitList(*scopes), " "))
+	if !ok {
+		log.Fatalf("Invalid scopes: %s", *scopes)
+	}
+
+	denylist, err := auth.NewDenylist(db)
 	if err != nil {
-		log.Fatalf("JWT manager initialization error: %v", err)
+		log.Fatalf("Token denylist initialization error: %v", err)
 	}
 
+	var notifier notify.Notifier
+	if *notifierKind == "mail" {
+		sender, err := notify.NewSender(*mailBackend, notify.MailConfig{
+			From:         *mailFrom,
+			SMTPHost:     *smtpHost,
+			SMTPPort:     *smtpPort,
+			SMTPUsername: *smtpUsername,
+			SMTPPassword: *smtpPassword,
+			Dir:          *mailDir,
+		})
+		if err != nil {
+			log.Fatalf("Mail sender initialization error: %v", err)
+		}
+
+		mailer, err := notify.NewMailer(db, sender, *mailMaxAttempts)
+		if err != nil {
+			log.Fatalf("Mailer initialization error: %v", err)
+		}
+		go mailer.Run(mailInterval)
+		notifier = mailer
+	} else {
+		notifier, err = notify.New(*notifierKind, *notifyFile)
+		if err != nil {
+			log.Fatalf("Notifier initialization error: %v", err)
+		}
+	}
+
+	go runCleanup(db, denylist)
+
 	authHandler := &handlers.AuthHandler{
-		DB:         db,
-		JWTManager: jwtManager,
+		DB:                   db,
+		JWTManager:           jwtManager,
+		Denylist:             denylist,
+		AdminAPIKey:          *adminAPIKey,
+		RefreshTokenTTL:      time.Duration(*refreshTTLHours) * time.Hour,
+		SessionMaxAge:        time.Duration(*sessionMaxAgeHours) * time.Hour,
+		SessionIdleTimeout:   time.Duration(*sessionIdleHours) * time.Hour,
+		Notifier:             notifier,
+		PasswordResetTTL:     time.Duration(*passwordResetTTLMinutes) * time.Minute,
+		PasswordResetURL:     *passwordResetURL,
+		RequireEmail:         *requireEmail,
+		EmailVerificationTTL: time.Duration(*emailVerificationTTLHours) * time.Hour,
+		EmailVerificationURL: *emailVerificationURL,
+		AccountDeletionGrace: time.Duration(*accountDeletionGraceHours) * time.Hour,
+		EnforceUserStatus:    *enforceUserStatus,
+		Scopes:               allowedScopes,
 	}
 
 	router := gin.Default()
@@ -51,12 +174,62 @@ func main() {
 	router.POST("/api/auth/register", authHandler.Register)
 	router.POST("/api/auth/login", authHandler.Login)
 	router.POST("/api/auth/refresh", authHandler.Refresh)
+	router.POST("/api/auth/switch-org", authHandler.SwitchOrg)
+	router.POST("/api/auth/revoke", authHandler.RevokeToken)
+	router.POST("/api/auth/password-reset", authHandler.RequestPasswordReset)
+	router.POST("/api/auth/password-reset/confirm", authHandler.ConfirmPasswordReset)
+	router.POST("/api/auth/verify-email", authHandler.VerifyEmail)
 	router.GET("/api/auth/public-key", authHandler.GetPublicKey)
+	router.GET("/.well-known/jwks.json", authHandler.GetJWKS)
+
+	router.POST("/api/auth/introspect", authHandler.ServiceAuthMiddleware(), authHandler.Introspect)
 
 	protected := router.Group("/api")
 	protected.Use(authHandler.AuthMiddleware())
 	{
-		protected.GET("/me", authHandler.GetMe)
+		// Выйти можно с любым токеном, независимо от скоупа
+		protected.POST("/auth/logout", authHandler.Logout)
+		protected.POST("/auth/logout-all", authHandler.LogoutAll)
+
+		profile := protected.Group("/me", handlers.RequireScope(auth.ScopeProfile))
+		profile.GET("", authHandler.GetMe)
+		profile.PATCH("", authHandler.UpdateProfile)
+		profile.DELETE("", authHandler.DeleteAccount)
+		profile.POST("/tokens/revoke", authHandler.RevokeOwnToken)
+		profile.PUT("/password", authHandler.ChangePassword)
+		profile.PUT("/email", authHandler.UpdateEmail)
+		profile.POST("/email/verify", authHandler.ResendEmailVerification)
+		profile.GET("/sessions", authHandler.ListSessions)
+		profile.DELETE("/sessions/:id", authHandler.RevokeSession)
+
+		orgs := protected.Group("", handlers.RequireScope(auth.ScopeOrgs))
+		orgs.GET("/me/orgs", authHandler.ListMyOrganizations)
+		orgs.POST("/orgs", authHandler.CreateOrganization)
+		orgs.POST("/orgs/join", authHandler.JoinOrganization)
+		orgs.GET("/orgs/:id/members", authHandler.ListOrgMembers)
+		orgs.PUT("/orgs/:id/members/:user_id", authHandler.SetOrgMemberRole)
+		orgs.DELETE("/orgs/:id/members/:user_id", authHandler.RemoveOrgMember)
+		orgs.POST("/orgs/:id/invites", authHandler.CreateOrgInvite)
+	}
+
+	admin := router.Group("/api/admin")
+	admin.Use(authHandler.AdminMiddleware())
+	{
+		admin.POST("/tokens/revoke", authHandler.AdminRevokeToken)
+		admin.GET("/users", authHandler.AdminListUsers)
+		admin.GET("/users/:id", authHandler.AdminGetUser)
+		admin.PATCH("/users/:id", authHandler.AdminUpdateUser)
+		admin.DELETE("/users/:id", authHandler.AdminDeleteUser)
+		admin.POST("/users/:id/disable", authHandler.AdminDisableUser)
+		admin.POST("/users/:id/enable", authHandler.AdminEnableUser)
+		admin.POST("/users/:id/logout", authHandler.AdminLogoutUser)
+		admin.PUT("/users/:id/roles/:role", authHandler.AdminGrantRole)
+		admin.DELETE("/users/:id/roles/:role", authHandler.AdminRevokeRole)
+		admin.GET("/roles", authHandler.AdminListRoles)
+		admin.POST("/roles", authHandler.AdminCreateRole)
+		admin.DELETE("/roles/:role", authHandler.AdminDeleteRole)
+		admin.PUT("/roles/:role/permissions/:permission", authHandler.AdminGrantPermission)
+		admin.DELETE("/roles/:role/permissions/:permission", authHandler.AdminRevokePermission)
 	}
 
 	log.Printf("Server started on %s", *addr)
@@ -65,6 +238,34 @@ func main() {
 	}
 }
 
+// runCleanup periodically drops expired tokens, purges accounts whose
+// deletion is due and picks up access tokens revoked by other server
+// instances.
+func runCleanup(db *database.DB, denylist *auth.Denylist) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := denylist.Sync(); err != nil {
+			log.Printf("Error syncing token denylist: %v", err)
+		}
+		if err := db.DeleteExpiredRefreshTokens(); err != nil {
+			log.Printf("Error deleting expired refresh tokens: %v", err)
+		}
+		if err := db.DeleteExpiredUserTokens(); err != nil {
+			log.Printf("Error deleting expired user tokens: %v", err)
+		}
+		if err := db.DeleteFinishedMail(time.Now().Add(-mailRetention)); err != nil {
+			log.Printf("Error deleting finished mail: %v", err)
+		}
+		if userIDs, err := db.PurgeDeletedUsers(time.Now()); err != nil {
+			log.Printf("Error purging deleted users: %v", err)
+		} else if len(userIDs) > 0 {
+			log.Printf("Purged deleted users: %v", userIDs)
+		}
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -79,4 +280,23 @@ func getEnvAsInt(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
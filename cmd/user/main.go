@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/database"
+	"github.com/user/user-server/pkg/models"
+	"github.com/user/user-server/pkg/notify"
+)
+
+const usage = `Usage: user [-db PATH] COMMAND [OPTIONS]
+
+Manages user accounts.
+
+Commands:
+  reset-password  Set a new password read from stdin, or issue a reset link
+                  with -link, and end every session of the user
+  disable         Disable or, with -lock, lock an account and end every
+                  session of the user
+  enable          Make a disabled or locked account active again
+  grant-role      Give a role, e.g. admin, to a user
+  revoke-role     Take a role away from a user and end every session of the
+                  user
+  list-roles      List roles with their permissions
+  create-role     Create a role
+  delete-role     Delete a role, take it away from every user and end
+                  every session of its users
+  grant-permission
+                  Add a permission to a role
+  revoke-permission
+                  Remove a permission from a role and end every session of
+                  its users
+`
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using default values or command line flags")
+	}
+
+	dbPath := flag.String("db", getEnv("DB_PATH", "user-server.db"), "Path to SQLite database file")
+	tokenSecret := flag.String("token-secret", getEnv("TOKEN_SECRET", ""), "Server secret used to hash tokens at rest, must match the server (required)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *tokenSecret == "" {
+		log.Fatal("Token secret is required, set TOKEN_SECRET or -token-secret")
+	}
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Fatalf("Database connection error: %v", err)
+	}
+	defer db.Close()
+
+	db.SetTokenKey([]byte(*tokenSecret))
+
+	if err := db.Initialize(); err != nil {
+		log.Fatalf("Database initialization error: %v", err)
+	}
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "reset-password":
+		err = resetPassword(db, args)
+	case "disable":
+		err = disableUser(db, args)
+	case "enable":
+		err = enableUser(db, args)
+	case "grant-role":
+		err = grantRole(db, args)
+	case "revoke-role":
+		err = revokeRole(db, args)
+	case "list-roles":
+		err = listRoles(db, args)
+	case "create-role":
+		err = createRole(db, args)
+	case "delete-role":
+		err = deleteRole(db, args)
+	case "grant-permission":
+		err = grantPermission(db, args)
+	case "revoke-permission":
+		err = revokePermission(db, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s error: %v", command, err)
+	}
+}
+
+func resetPassword(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	link := flags.Bool("link", false, "Issue a one-time reset token instead of setting the password")
+	baseURL := flags.String("base-url", getEnv("PASSWORD_RESET_URL", ""), "Password reset page URL the token is added to with -link")
+	ttlMinutes := flags.Int("ttl", getEnvAsInt("PASSWORD_RESET_TTL", 60), "Reset token lifetime in minutes with -link")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: user reset-password [OPTIONS] USERNAME")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	user, err := db.GetUserByUsername(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("user %s not found: %w", flags.Arg(0), err)
+	}
+
+	if *link {
+		token, err := auth.GenerateRandomToken(32)
+		if err != nil {
+			return fmt.Errorf("reset token generation error: %w", err)
+		}
+
+		resetToken, err := db.CreateUserToken(user.ID, models.UserTokenPurposePasswordReset, token, "", time.Now().Add(time.Duration(*ttlMinutes)*time.Minute))
+		if err != nil {
+			return fmt.Errorf("reset token save error: %w", err)
+		}
+
+		resetLink, err := notify.Link(*baseURL, token)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Reset token: %s\n", token)
+		if resetLink != "" {
+			fmt.Printf("Reset link:  %s\n", resetLink)
+		}
+		fmt.Printf("Expires at:  %s\n", resetToken.ExpiresAt.Format(time.RFC3339))
+	} else {
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		hashedPassword, err := auth.HashPassword(password)
+		if err != nil {
+			return fmt.Errorf("password hashing error: %w", err)
+		}
+
+		if err := db.UpdateUserPassword(user.ID, hashedPassword); err != nil {
+			return fmt.Errorf("password update error: %w", err)
+		}
+		fmt.Printf("Password updated for user %s\n", user.Username)
+	}
+
+	ended, err := endSessions(db, user.ID, "password reset by admin")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Ended %d session(s)\n", ended)
+	return nil
+}
+
+func disableUser(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("disable", flag.ExitOnError)
+	reason := flags.String("reason", "", "Why the account is disabled, shown to admins only")
+	lock := flags.Bool("lock", false, "Mark the account locked for security reasons instead of disabled")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: user disable [OPTIONS] USERNAME")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	user, err := db.GetUserByUsername(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("user %s not found: %w", flags.Arg(0), err)
+	}
+	if user.Status == models.UserStatusPendingDeletion {
+		return fmt.Errorf("user %s is scheduled for deletion", user.Username)
+	}
+
+	status := models.UserStatusDisabled
+	if *lock {
+		status = models.UserStatusLocked
+	}
+	if err := db.SetUserStatus(user.ID, status, *reason); err != nil {
+		return fmt.Errorf("status update error: %w", err)
+	}
+	fmt.Printf("User %s is now %s\n", user.Username, status)
+
+	ended, err := endSessions(db, user.ID, "account "+status)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Ended %d session(s)\n", ended)
+	return nil
+}
+
+func enableUser(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("enable", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: user enable USERNAME")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	user, err := db.GetUserByUsername(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("user %s not found: %w", flags.Arg(0), err)
+	}
+
+	// Удаление отменяет только сам пользователь, войдя в аккаунт
+	switch user.Status {
+	case models.UserStatusActive:
+		fmt.Printf("User %s is already active\n", user.Username)
+		return nil
+	case models.UserStatusPendingDeletion:
+		return fmt.Errorf("user %s is scheduled for deletion", user.Username)
+	}
+
+	if err := db.SetUserStatus(user.ID, models.UserStatusActive, ""); err != nil {
+		return fmt.Errorf("status update error: %w", err)
+	}
+	fmt.Printf("User %s is now active\n", user.Username)
+	return nil
+}
+
+func grantRole(db *database.DB, args []string) error {
+	user, role, err := parseRoleArgs(db, "grant-role", args)
+	if err != nil {
+		return err
+	}
+
+	added, err := db.AddUserRole(user.ID, role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("role %s does not exist, create it with create-role", role)
+	}
+	if err != nil {
+		return fmt.Errorf("role update error: %w", err)
+	}
+	if !added {
+		fmt.Printf("User %s already has role %s\n", user.Username, role)
+		return nil
+	}
+	fmt.Printf("Granted role %s to user %s, effective from the next login or token refresh\n", role, user.Username)
+	return nil
+}
+
+func revokeRole(db *database.DB, args []string) error {
+	user, role, err := parseRoleArgs(db, "revoke-role", args)
+	if err != nil {
+		return err
+	}
+
+	removed, err := db.RemoveUserRole(user.ID, role)
+	if err != nil {
+		return fmt.Errorf("role update error: %w", err)
+	}
+	if !removed {
+		fmt.Printf("User %s does not have role %s\n", user.Username, role)
+		return nil
+	}
+	fmt.Printf("Revoked role %s from user %s\n", role, user.Username)
+
+	// Роли зашиты в выданные токены, поэтому завершаем сессии
+	ended, err := endSessions(db, user.ID, "role "+role+" revoked")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Ended %d session(s)\n", ended)
+	return nil
+}
+
+// parseRoleArgs parses the USERNAME ROLE arguments of the role commands.
+func parseRoleArgs(db *database.DB, command string, args []string) (*models.User, string, error) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: user %s USERNAME ROLE\n", command)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	role := flags.Arg(1)
+	if !models.RoleNamePattern.MatchString(role) {
+		return nil, "", fmt.Errorf("invalid role name %q", role)
+	}
+
+	user, err := db.GetUserByUsername(flags.Arg(0))
+	if err != nil {
+		return nil, "", fmt.Errorf("user %s not found: %w", flags.Arg(0), err)
+	}
+	return user, role, nil
+}
+
+func listRoles(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("list-roles", flag.ExitOnError)
+	flags.Parse(args)
+
+	roles, err := db.ListRoles()
+	if err != nil {
+		return fmt.Errorf("role list error: %w", err)
+	}
+
+	for _, role := range roles {
+		fmt.Printf("%s\t%d user(s)\t%s\t%s\n", role.Name, role.Users, strings.Join(role.Permissions, ","), role.Description)
+	}
+	return nil
+}
+
+func createRole(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("create-role", flag.ExitOnError)
+	description := flags.String("description", "", "What the role is for")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: user create-role [OPTIONS] ROLE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	role := flags.Arg(0)
+	if !models.RoleNamePattern.MatchString(role) {
+		return fmt.Errorf("invalid role name %q", role)
+	}
+
+	created, err := db.CreateRole(role, *description)
+	if err != nil {
+		return fmt.Errorf("role creation error: %w", err)
+	}
+	if !created {
+		return fmt.Errorf("role %s already exists", role)
+	}
+	fmt.Printf("Created role %s\n", role)
+	return nil
+}
+
+func deleteRole(db *database.DB, args []string) error {
+	flags := flag.NewFlagSet("delete-role", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: user delete-role ROLE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	role := flags.Arg(0)
+	if role == models.RoleAdmin {
+		return fmt.Errorf("role %s cannot be deleted", role)
+	}
+
+	userIDs, deleted, err := db.DeleteRole(role)
+	if err != nil {
+		return fmt.Errorf("role deletion error: %w", err)
+	}
+	if !deleted {
+		return fmt.Errorf("role %s does not exist", role)
+	}
+
+	fmt.Printf("Deleted role %s\n", role)
+
+	ended, err := endRoleSessions(db, userIDs, "role "+role+" deleted")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Ended %d session(s) of %d user(s)\n", ended, len(userIDs))
+	return nil
+}
+
+func grantPermission(db *database.DB, args []string) error {
+	role, permission, err := parsePermissionArgs("grant-permission", args)
+	if err != nil {
+		return err
+	}
+
+	added, err := db.AddRolePermission(role, permission)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("role %s does not exist, create it with create-role", role)
+	}
+	if err != nil {
+		return fmt.Errorf("permission update error: %w", err)
+	}
+	if !added {
+		fmt.Printf("Role %s already has permission %s\n", role, permission)
+		return nil
+	}
+	fmt.Printf("Granted permission %s to role %s, effective from the next login or token refresh of its users\n", permission, role)
+	return nil
+}
+
+func revokePermission(db *database.DB, args []string) error {
+	role, permission, err := parsePermissionArgs("revoke-permission", args)
+	if err != nil {
+		return err
+	}
+
+	userIDs, removed, err := db.RemoveRolePermission(role, permission)
+	if err != nil {
+		return fmt.Errorf("permission update error: %w", err)
+	}
+	if !removed {
+		fmt.Printf("Role %s does not have permission %s\n", role, permission)
+		return nil
+	}
+
+	fmt.Printf("Revoked permission %s from role %s\n", permission, role)
+
+	ended, err := endRoleSessions(db, userIDs, "permission "+permission+" revoked from role "+role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Ended %d session(s) of %d user(s)\n", ended, len(userIDs))
+	return nil
+}
+
+// parsePermissionArgs parses the ROLE PERMISSION arguments of the permission
+// commands.
+func parsePermissionArgs(command string, args []string) (string, string, error) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: user %s ROLE PERMISSION\n", command)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if !models.RoleNamePattern.MatchString(flags.Arg(1)) {
+		return "", "", fmt.Errorf("invalid permission name %q", flags.Arg(1))
+	}
+	return flags.Arg(0), flags.Arg(1), nil
+}
+
+// readPassword reads the new password from the first line of stdin.
+func readPassword() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "New password: ")
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("password read error: %w", err)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters long")
+	}
+	return password, nil
+}
+
+// endSessions deletes the refresh tokens of a user and denylists the latest
+// access token of each session. Running servers pick the revocations up on
+// their next cleanup run.
+func endSessions(db *database.DB, userID int64, reason string) (int, error) {
+	sessions, err := db.ListUserSessions(userID)
+	if err != nil {
+		return 0, fmt.Errorf("session list error: %w", err)
+	}
+
+	if err := db.DeleteUserRefreshTokens(userID); err != nil {
+		return 0, fmt.Errorf("refresh token deletion error: %w", err)
+	}
+
+	now := time.Now()
+	for _, session := range sessions {
+		if session.AccessTokenID == "" || !session.AccessExpiresAt.After(now) {
+			continue
+		}
+		err := db.RevokeToken(&models.RevokedToken{
+			TokenID:   session.AccessTokenID,
+			UserID:    userID,
+			Reason:    reason,
+			ExpiresAt: session.AccessExpiresAt,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("access token revocation error: %w", err)
+		}
+	}
+
+	return len(sessions), nil
+}
+
+// endRoleSessions ends every session of the given users, whose access tokens
+// still carry a role or permission they lost.
+func endRoleSessions(db *database.DB, userIDs []int64, reason string) (int, error) {
+	total := 0
+	for _, userID := range userIDs {
+		ended, err := endSessions(db, userID, reason)
+		if err != nil {
+			return total, err
+		}
+		total += ended
+	}
+	return total, nil
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsInt(key string, defaultValue int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
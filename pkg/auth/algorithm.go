@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var signingMethods = map[string]jwt.SigningMethod{
+	jwt.SigningMethodRS256.Alg(): jwt.SigningMethodRS256,
+	jwt.SigningMethodPS256.Alg(): jwt.SigningMethodPS256,
+	jwt.SigningMethodES256.Alg(): jwt.SigningMethodES256,
+	jwt.SigningMethodEdDSA.Alg(): jwt.SigningMethodEdDSA,
+}
+
+// SupportedAlgorithms lists the JWS algorithms keys can be used with.
+func SupportedAlgorithms() []string {
+	algs := make([]string, 0, len(signingMethods))
+	for alg := range signingMethods {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// DefaultAlgorithm infers the algorithm for a public key: RS256 for RSA,
+// ES256 for P-256 ECDSA and EdDSA for Ed25519.
+func DefaultAlgorithm(publicKey crypto.PublicKey) (string, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256.Alg(), nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve %s, only P-256 is supported", key.Curve.Params().Name)
+		}
+		return jwt.SigningMethodES256.Alg(), nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA.Alg(), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", publicKey)
+	}
+}
+
+// ValidateAlgorithm checks that alg is supported and can be used with the
+// given public key.
+func ValidateAlgorithm(alg string, publicKey crypto.PublicKey) error {
+	if _, ok := signingMethods[alg]; !ok {
+		return fmt.Errorf("unsupported algorithm %q, expected one of %s", alg, strings.Join(SupportedAlgorithms(), ", "))
+	}
+
+	expected, err := DefaultAlgorithm(publicKey)
+	if err != nil {
+		return err
+	}
+
+	// PS256 is the only algorithm that shares a key type with another one.
+	if alg == expected || (alg == jwt.SigningMethodPS256.Alg() && expected == jwt.SigningMethodRS256.Alg()) {
+		return nil
+	}
+	return fmt.Errorf("algorithm %s cannot be used with %T", alg, publicKey)
+}
+
+// signingMethod returns the JWT signing method used with a key.
+func signingMethod(key *SigningKey) jwt.SigningMethod {
+	return signingMethods[key.Algorithm]
+}
@@ -2,97 +2,150 @@ package auth
 
 import (
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/user/user-server/pkg/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	ErrInvalidToken = errors.New("invalid token")
-	ErrExpiredToken = errors.New("token expired")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrExpiredToken    = errors.New("token expired")
+	ErrInvalidIssuer   = errors.New("token issuer mismatch")
+	ErrInvalidAudience = errors.New("token audience mismatch")
 )
 
 type JWTManager struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
-	tokenTTL   time.Duration
+	keyRing  *KeyRing
+	tokenTTL time.Duration
+	issuer   string
+	audience []string
 }
 
 type Claims struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
+	// SessionID identifies the login the token was issued in.
+	SessionID     string   `json:"sid,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	// Scope is the space-separated list of scopes the client asked for.
+	// Empty means the token is not restricted to any scopes.
+	Scope string `json:"scope,omitempty"`
+	// OrgID is the organization active in the session and OrgRole the role
+	// of the user in it. Both are empty if no organization is active.
+	OrgID   int64  `json:"org_id,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewJWTManager(privateKeyPath, publicKeyPath string, tokenTTL time.Duration) (*JWTManager, error) {
-	privateKeyBytes, err := os.ReadFile(privateKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("private key read error: %w", err)
-	}
+// HasRole reports whether the token was issued to a user with role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("private key parse error: %w", err)
-	}
+// HasPermission reports whether any role of the user grants permission.
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
 
-	publicKeyBytes, err := os.ReadFile(publicKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("public key read error: %w", err)
+// NewJWTManager creates a manager that signs tokens with the current key of
+// keyRing. Issued tokens name issuer and audience; when set, VerifyToken
+// requires the same issuer and at least one of the audiences.
+func NewJWTManager(keyRing *KeyRing, tokenTTL time.Duration, issuer string, audience []string) *JWTManager {
+	return &JWTManager{
+		keyRing:  keyRing,
+		tokenTTL: tokenTTL,
+		issuer:   issuer,
+		audience: audience,
 	}
+}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyBytes)
+// GenerateToken issues an access token for a session, limited to scope
+// unless it is empty, and returns it along with its claims. membership is
+// the user's membership in the active organization, nil if there is none.
+func (m *JWTManager) GenerateToken(user *models.User, sessionID, scope string, membership *models.OrgMembership) (string, *Claims, error) {
+	tokenID, err := GenerateTokenID()
 	if err != nil {
-		return nil, fmt.Errorf("public key parse error: %w", err)
+		return "", nil, fmt.Errorf("token ID generation error: %w", err)
 	}
 
-	return &JWTManager{
-		privateKey: privateKey,
-		publicKey:  publicKey,
-		tokenTTL:   tokenTTL,
-	}, nil
-}
-
-func (m *JWTManager) GenerateToken(userID int64, username string) (string, error) {
 	now := time.Now()
-	claims := Claims{
-		UserID:   userID,
-		Username: username,
+	claims := &Claims{
+		UserID:        user.ID,
+		Username:      user.Username,
+		SessionID:     sessionID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Roles:         user.Roles,
+		Permissions:   user.Permissions,
+		Scope:         scope,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Audience:  m.audience,
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.tokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(m.privateKey)
+	if membership != nil {
+		claims.OrgID = membership.OrgID
+		claims.OrgRole = membership.Role
+	}
+
+	key := m.keyRing.Current()
+	token := jwt.NewWithClaims(signingMethod(key), claims)
+	token.Header["kid"] = key.ID
+	signedToken, err := token.SignedString(key.PrivateKey)
 	if err != nil {
-		return "", fmt.Errorf("token signing error: %w", err)
+		return "", nil, fmt.Errorf("token signing error: %w", err)
 	}
 
-	return signedToken, nil
+	return signedToken, claims, nil
 }
 
 func (m *JWTManager) VerifyToken(tokenString string) (*Claims, error) {
+	return m.verifyToken(tokenString, m.audience)
+}
+
+// VerifyTokenForAudience verifies a token on behalf of a single service,
+// requiring audience to be one of the token's audiences.
+func (m *JWTManager) VerifyTokenForAudience(tokenString, audience string) (*Claims, error) {
+	return m.verifyToken(tokenString, []string{audience})
+}
+
+func (m *JWTManager) verifyToken(tokenString string, audience []string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&Claims{},
 		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodRSA)
-			if !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			key, err := m.verificationKey(token)
+			if err != nil {
+				return nil, err
 			}
-			return m.publicKey, nil
+			// Each key only verifies tokens signed with its own algorithm.
+			if token.Method.Alg() != key.Algorithm {
+				return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], key.ID)
+			}
+			return key.PublicKey, nil
 		},
+		jwt.WithValidMethods(SupportedAlgorithms()),
 	)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -106,11 +159,52 @@ func (m *JWTManager) VerifyToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
+	if m.issuer != "" && claims.Issuer != m.issuer {
+		return nil, ErrInvalidIssuer
+	}
+
+	if len(audience) > 0 && !slices.ContainsFunc(audience, func(aud string) bool {
+		return slices.Contains(claims.Audience, aud)
+	}) {
+		return nil, ErrInvalidAudience
+	}
+
 	return claims, nil
 }
 
+func (m *JWTManager) verificationKey(token *jwt.Token) (*SigningKey, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		// Tokens issued before key IDs were introduced carry no kid.
+		return m.keyRing.Current(), nil
+	}
+
+	key, ok := m.keyRing.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+	if key.Expired(time.Now()) {
+		return nil, fmt.Errorf("key %s is no longer accepted", kid)
+	}
+
+	return key, nil
+}
+
+func (m *JWTManager) GetJWKS() (*JWKSet, error) {
+	jwks := &JWKSet{Keys: []JWK{}}
+	for _, key := range m.keyRing.VerificationKeys() {
+		jwk, err := NewJWK(key.PublicKey, key.ID, key.Algorithm)
+		if err != nil {
+			return nil, fmt.Errorf("error building JWK for key %s: %w", key.ID, err)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
+
 func (m *JWTManager) GetPublicKeyPEM() (string, error) {
-	publicKeyBytes, err := x509.MarshalPKIXPublicKey(m.publicKey)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(m.keyRing.Current().PublicKey)
 	if err != nil {
 		return "", fmt.Errorf("error marshaling public key: %w", err)
 	}
@@ -150,6 +244,16 @@ func GenerateInviteToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// GenerateTokenID returns a random identifier for the jti claim.
+func GenerateTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 func GenerateRandomToken(length int) (string, error) {
 	b := make([]byte, length)
 	_, err := rand.Read(b)
@@ -160,20 +264,31 @@ func GenerateRandomToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
+// HashClientSecret hashes a service client secret for storage. Secrets are
+// long random values, so a fast hash is sufficient.
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func CheckClientSecret(secret, secretHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashClientSecret(secret)), []byte(secretHash)) == 1
+}
+
 func GenerateRefreshToken() (string, error) {
 	return GenerateRandomToken(32)
 }
 
-func (m *JWTManager) GenerateTokenPair(userID int64, username string) (string, string, error) {
-	accessToken, err := m.GenerateToken(userID, username)
+func (m *JWTManager) GenerateTokenPair(user *models.User, sessionID, scope string, membership *models.OrgMembership) (string, string, *Claims, error) {
+	accessToken, claims, err := m.GenerateToken(user, sessionID, scope, membership)
 	if err != nil {
-		return "", "", fmt.Errorf("error generating access token: %w", err)
+		return "", "", nil, fmt.Errorf("error generating access token: %w", err)
 	}
 
 	refreshToken, err := GenerateRefreshToken()
 	if err != nil {
-		return "", "", fmt.Errorf("error generating refresh token: %w", err)
+		return "", "", nil, fmt.Errorf("error generating refresh token: %w", err)
 	}
 
-	return accessToken, refreshToken, nil
+	return accessToken, refreshToken, claims, nil
 } 
\ No newline at end of file
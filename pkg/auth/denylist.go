@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// RevocationStore persists revoked access tokens.
+type RevocationStore interface {
+	RevokeToken(token *models.RevokedToken) error
+	GetActiveRevokedTokens() ([]models.RevokedToken, error)
+	DeleteExpiredRevokedTokens() error
+}
+
+// Denylist tracks revoked access tokens by jti. Revocations are written to
+// the store and cached in memory, so checking a token never hits the
+// database. Entries are dropped once the token would have expired anyway.
+type Denylist struct {
+	store   RevocationStore
+	mu      sync.RWMutex
+	entries map[string]time.Time
+}
+
+func NewDenylist(store RevocationStore) (*Denylist, error) {
+	d := &Denylist{
+		store:   store,
+		entries: make(map[string]time.Time),
+	}
+	if err := d.Sync(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Revoke denylists the token described by claims until it expires.
+func (d *Denylist) Revoke(claims *Claims, reason string) error {
+	if claims.ID == "" {
+		return ErrInvalidToken
+	}
+
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return d.RevokeID(claims.ID, claims.UserID, expiresAt, reason)
+}
+
+// RevokeID denylists a token by jti until expiresAt.
+func (d *Denylist) RevokeID(tokenID string, userID int64, expiresAt time.Time, reason string) error {
+	if !expiresAt.After(time.Now()) {
+		return nil
+	}
+
+	err := d.store.RevokeToken(&models.RevokedToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error saving revoked token: %w", err)
+	}
+
+	d.mu.Lock()
+	d.entries[tokenID] = expiresAt
+	d.mu.Unlock()
+
+	return nil
+}
+
+func (d *Denylist) IsRevoked(tokenID string) bool {
+	d.mu.RLock()
+	expiresAt, ok := d.entries[tokenID]
+	d.mu.RUnlock()
+
+	return ok && time.Now().Before(expiresAt)
+}
+
+// Sync prunes expired entries and reloads the cache from the store, picking
+// up revocations made by other server instances.
+func (d *Denylist) Sync() error {
+	if err := d.store.DeleteExpiredRevokedTokens(); err != nil {
+		return fmt.Errorf("error deleting expired revoked tokens: %w", err)
+	}
+
+	tokens, err := d.store.GetActiveRevokedTokens()
+	if err != nil {
+		return fmt.Errorf("error loading revoked tokens: %w", err)
+	}
+
+	entries := make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		entries[token.TokenID] = token.ExpiresAt
+	}
+
+	// Revocations are never undone, so entries cached since the store was
+	// read are kept rather than lost until the next sync.
+	now := time.Now()
+	d.mu.Lock()
+	for tokenID, expiresAt := range d.entries {
+		if now.Before(expiresAt) {
+			entries[tokenID] = expiresAt
+		}
+	}
+	d.entries = entries
+	d.mu.Unlock()
+
+	return nil
+}
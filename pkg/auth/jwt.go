@@ -4,4 +4,8 @@ import "time"
 
 func (m *JWTManager) GetTokenTTL() time.Duration {
 	return m.tokenTTL
-} 
\ No newline at end of file
+}
+
+func (m *JWTManager) GetKeyID() string {
+	return m.keyRing.Current().ID
+}
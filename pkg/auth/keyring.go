@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// KeyRingManifestFile is the name of the manifest describing a key ring
+// directory.
+const KeyRingManifestFile = "keyring.json"
+
+var ErrNoCurrentKey = errors.New("key ring has no current signing key")
+
+type KeyStatus string
+
+const (
+	// KeyStatusCurrent marks the key used to sign new tokens.
+	KeyStatusCurrent KeyStatus = "current"
+	// KeyStatusNext marks a staged key that is published but not yet used
+	// for signing.
+	KeyStatusNext KeyStatus = "next"
+	// KeyStatusPrevious marks a retired signing key that still verifies
+	// tokens until its grace window runs out.
+	KeyStatusPrevious KeyStatus = "previous"
+)
+
+type KeyRingEntry struct {
+	ID        string     `json:"kid"`
+	File      string     `json:"file"`
+	Status    KeyStatus  `json:"status"`
+	Algorithm string     `json:"alg,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+type KeyRingManifest struct {
+	Keys []KeyRingEntry `json:"keys"`
+}
+
+func (m *KeyRingManifest) Find(kid string) *KeyRingEntry {
+	for i := range m.Keys {
+		if m.Keys[i].ID == kid {
+			return &m.Keys[i]
+		}
+	}
+	return nil
+}
+
+func (m *KeyRingManifest) WithStatus(status KeyStatus) []*KeyRingEntry {
+	var entries []*KeyRingEntry
+	for i := range m.Keys {
+		if m.Keys[i].Status == status {
+			entries = append(entries, &m.Keys[i])
+		}
+	}
+	return entries
+}
+
+func (m *KeyRingManifest) Remove(kid string) {
+	for i := range m.Keys {
+		if m.Keys[i].ID == kid {
+			m.Keys = append(m.Keys[:i], m.Keys[i+1:]...)
+			return
+		}
+	}
+}
+
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	// ExpiresAt is zero for keys that do not expire.
+	ExpiresAt time.Time
+}
+
+func (k *SigningKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// KeyRing holds the current signing key together with every key that is
+// still accepted for verification.
+type KeyRing struct {
+	current *SigningKey
+	keys    []*SigningKey
+	byID    map[string]*SigningKey
+}
+
+func NewKeyRing(current *SigningKey, others ...*SigningKey) (*KeyRing, error) {
+	if current == nil || current.PrivateKey == nil {
+		return nil, ErrNoCurrentKey
+	}
+
+	ring := &KeyRing{
+		current: current,
+		byID:    make(map[string]*SigningKey),
+	}
+	for _, key := range append([]*SigningKey{current}, others...) {
+		if _, exists := ring.byID[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key ID: %s", key.ID)
+		}
+		if err := ValidateAlgorithm(key.Algorithm, key.PublicKey); err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.ID, err)
+		}
+		ring.keys = append(ring.keys, key)
+		ring.byID[key.ID] = key
+	}
+
+	return ring, nil
+}
+
+func (r *KeyRing) Current() *SigningKey {
+	return r.current
+}
+
+func (r *KeyRing) Lookup(kid string) (*SigningKey, bool) {
+	key, ok := r.byID[kid]
+	return key, ok
+}
+
+// VerificationKeys returns the keys that have not passed their grace window.
+func (r *KeyRing) VerificationKeys() []*SigningKey {
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		if !key.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LoadKeyPair builds a single-key ring from a PEM private/public key pair. An
+// empty alg is inferred from the key type.
+func LoadKeyPair(privateKeyPath, publicKeyPath, alg string) (*KeyRing, error) {
+	privateKeyBytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("private key read error: %w", err)
+	}
+
+	privateKey, err := ParsePrivateKeyPEM(privateKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("private key parse error: %w", err)
+	}
+
+	publicKeyBytes, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("public key read error: %w", err)
+	}
+
+	publicKey, err := ParsePublicKeyPEM(publicKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("public key parse error: %w", err)
+	}
+
+	if key, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !key.Equal(privateKey.Public()) {
+		return nil, errors.New("public key does not match private key")
+	}
+
+	key, err := NewSigningKey(privateKey, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyRing(key)
+}
+
+// LoadKeyRing loads the keys listed in the manifest of dir. Previous keys
+// remain valid for verification for grace after they were retired and are
+// skipped entirely once that window has passed. A previous key without a
+// retirement time is treated as expired, as `keys retire` does.
+func LoadKeyRing(dir string, grace time.Duration) (*KeyRing, error) {
+	manifest, err := ReadKeyRingManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var current *SigningKey
+	var others []*SigningKey
+	for _, entry := range manifest.Keys {
+		var expiresAt time.Time
+		if entry.Status == KeyStatusPrevious {
+			if entry.RetiredAt == nil {
+				continue
+			}
+			expiresAt = entry.RetiredAt.Add(grace)
+			if now.After(expiresAt) {
+				continue
+			}
+		}
+
+		key, err := loadKeyRingEntry(dir, entry)
+		if err != nil {
+			return nil, err
+		}
+		key.ExpiresAt = expiresAt
+
+		switch entry.Status {
+		case KeyStatusCurrent:
+			if current != nil {
+				return nil, fmt.Errorf("key ring has more than one current key: %s, %s", current.ID, key.ID)
+			}
+			current = key
+		case KeyStatusNext, KeyStatusPrevious:
+			others = append(others, key)
+		default:
+			return nil, fmt.Errorf("key %s has unknown status %q", entry.ID, entry.Status)
+		}
+	}
+
+	return NewKeyRing(current, others...)
+}
+
+func loadKeyRingEntry(dir string, entry KeyRingEntry) (*SigningKey, error) {
+	data, err := os.ReadFile(filepath.Join(dir, entry.File))
+	if err != nil {
+		return nil, fmt.Errorf("key %s read error: %w", entry.ID, err)
+	}
+
+	privateKey, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("key %s parse error: %w", entry.ID, err)
+	}
+
+	key, err := NewSigningKey(privateKey, entry.Algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", entry.ID, err)
+	}
+
+	if key.ID != entry.ID {
+		return nil, fmt.Errorf("key %s: file %s has key ID %s", entry.ID, entry.File, key.ID)
+	}
+
+	return key, nil
+}
+
+// NewSigningKey wraps a private key, deriving its key ID from the public key.
+// An empty alg is inferred from the key type, otherwise it is validated
+// against it.
+func NewSigningKey(privateKey crypto.Signer, alg string) (*SigningKey, error) {
+	publicKey := privateKey.Public()
+	if alg == "" {
+		var err error
+		if alg, err = DefaultAlgorithm(publicKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidateAlgorithm(alg, publicKey); err != nil {
+		return nil, err
+	}
+
+	kid, err := Thumbprint(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("key ID computation error: %w", err)
+	}
+
+	return &SigningKey{
+		ID:         kid,
+		Algorithm:  alg,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// GenerateSigningKey creates a private key of the given type: "rsa" (with
+// bits as the modulus size), "ecdsa" (P-256) or "ed25519".
+func GenerateSigningKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits, got %d", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 (RSA) or SEC 1 (ECDSA) encoded
+// private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// ParsePublicKeyPEM parses a PKIX or PKCS#1 encoded public key.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// MarshalPrivateKeyPEM encodes a private key as a PKCS#8 PEM block.
+func MarshalPrivateKeyPEM(privateKey crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func ReadKeyRingManifest(dir string) (*KeyRingManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, KeyRingManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("key ring manifest read error: %w", err)
+	}
+
+	manifest := &KeyRingManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("key ring manifest parse error: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// WriteKeyRingManifest replaces the manifest of dir atomically, so a server
+// starting concurrently never reads a partially written file.
+func WriteKeyRingManifest(dir string, manifest *KeyRingManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("key ring manifest marshal error: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, KeyRingManifestFile+".*")
+	if err != nil {
+		return fmt.Errorf("key ring manifest write error: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("key ring manifest write error: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("key ring manifest write error: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, KeyRingManifestFile)); err != nil {
+		return fmt.Errorf("key ring manifest write error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// Scopes required by the built-in routes.
+const (
+	ScopeProfile = "profile" // the /api/me endpoints
+	ScopeOrgs    = "orgs"    // the organization endpoints
+	ScopeAdmin   = "admin"   // the admin API
+)
+
+// ParseScope splits a space-separated scope string (RFC 6749, section 3.3)
+// into its sorted, distinct scope tokens. It reports false if a token holds
+// characters the RFC does not allow.
+func ParseScope(scope string) ([]string, bool) {
+	tokens := strings.Fields(scope)
+	for _, token := range tokens {
+		for _, r := range token {
+			if r < 0x21 || r > 0x7e || r == '"' || r == '\\' {
+				return nil, false
+			}
+		}
+	}
+
+	slices.Sort(tokens)
+	return slices.Compact(tokens), true
+}
+
+// FormatScope joins scope tokens into a scope string.
+func FormatScope(tokens []string) string {
+	return strings.Join(tokens, " ")
+}
+
+// HasScope reports whether the token grants scope. Tokens without a scope
+// claim grant every scope.
+func (c *Claims) HasScope(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// userTables lists the tables holding rows of a user, deleted together with
+// the user. Revoked access tokens are kept: they must stay denylisted until
+// they expire.
+var userTables = []string{"refresh_tokens", "sessions", "user_tokens", "security_events", "user_roles", "org_memberships"}
+
+// ErrLastOrgOwner is returned when deleting a user, or changing or removing
+// a membership, would leave an organization without an owner.
+var ErrLastOrgOwner = errors.New("user is the last owner of an organization")
+
+// soleOwnerCondition matches the memberships m of users who are the only
+// owner of their organization.
+const soleOwnerCondition = "m.role = '" + models.OrgRoleOwner + "' AND NOT EXISTS (" +
+	"SELECT 1 FROM org_memberships o WHERE o.org_id = m.org_id AND o.role = '" + models.OrgRoleOwner + "' AND o.user_id != m.user_id)"
+
+// IsLastOrgOwner reports whether a user is the only owner of an
+// organization, and so cannot be deleted.
+func (db *DB) IsLastOrgOwner(userID int64) (bool, error) {
+	return isLastOrgOwner(db, userID)
+}
+
+func isLastOrgOwner(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, userID int64) (bool, error) {
+	var exists bool
+	err := q.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM org_memberships m WHERE m.user_id = ? AND "+soleOwnerCondition+")",
+		userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// ScheduleUserDeletion marks an active user for deletion at deleteAfter. It
+// reports false if the user is not active, so that a disabled or locked
+// account cannot leave that status through a deletion it then cancels.
+func (db *DB) ScheduleUserDeletion(userID int64, deleteAfter time.Time) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE users SET status = ?, delete_after = ?, updated_at = ? WHERE id = ? AND status = ?",
+		models.UserStatusPendingDeletion, deleteAfter, time.Now(), userID, models.UserStatusActive,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// CancelUserDeletion clears a scheduled deletion and makes the account active
+// again. It reports false if the user is not pending deletion.
+func (db *DB) CancelUserDeletion(userID int64) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE users SET status = ?, delete_after = NULL, updated_at = ? WHERE id = ? AND status = ?",
+		models.UserStatusActive, time.Now(), userID, models.UserStatusPendingDeletion,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// PurgeDeletedUsers deletes users whose scheduled deletion is due, along with
+// their data, and returns their IDs. The last owner of an organization is
+// kept until the organization has another owner.
+func (db *DB) PurgeDeletedUsers(now time.Time) ([]int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT id FROM users u WHERE delete_after IS NOT NULL AND delete_after <= ? AND NOT EXISTS (SELECT 1 FROM org_memberships m WHERE m.user_id = u.id AND "+soleOwnerCondition+")",
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, userID := range userIDs {
+		if _, err := deleteUser(tx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return userIDs, tx.Commit()
+}
+
+// DeleteUser deletes a user right away, along with their data. It reports
+// false if there was no such user and returns ErrLastOrgOwner if the user is
+// the only owner of an organization.
+func (db *DB) DeleteUser(userID int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteUser(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	return deleted, tx.Commit()
+}
+
+func deleteUser(tx *sql.Tx, userID int64) (bool, error) {
+	if last, err := isLastOrgOwner(tx, userID); err != nil {
+		return false, err
+	} else if last {
+		return false, ErrLastOrgOwner
+	}
+
+	for _, table := range userTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return false, err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
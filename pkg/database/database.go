@@ -1,17 +1,27 @@
 package database
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	"github.com/user/user-server/pkg/models"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/user/user-server/pkg/models"
 )
 
+var ErrNoTokenKey = errors.New("token hashing key is not set")
+
+// ErrInviteTokenUsed is returned when an invite token has already been used.
+var ErrInviteTokenUsed = errors.New("invite token already used")
+
 type DB struct {
 	*sql.DB
+	tokenKey []byte
 }
 
 func New(dataSourceName string) (*DB, error) {
@@ -24,7 +34,25 @@ func New(dataSourceName string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
+}
+
+// SetTokenKey sets the server secret used to hash bearer tokens at rest.
+// Changing it invalidates every stored token.
+func (db *DB) SetTokenKey(key []byte) {
+	db.tokenKey = key
+}
+
+// hashToken returns the keyed hash under which a bearer token is stored, so
+// that reading the database does not reveal usable tokens.
+func (db *DB) hashToken(token string) (string, error) {
+	if len(db.tokenKey) == 0 {
+		return "", ErrNoTokenKey
+	}
+
+	mac := hmac.New(sha256.New, db.tokenKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil)), nil
 }
 
 func (db *DB) Initialize() error {
@@ -41,26 +69,59 @@ func (db *DB) Initialize() error {
 		return fmt.Errorf("error creating users table: %w", err)
 	}
 
+	if err := db.migrateUserEmails(); err != nil {
+		return fmt.Errorf("error migrating users table: %w", err)
+	}
+
+	for _, column := range []string{"display_name", "avatar_url", "locale", "timezone"} {
+		if _, err := db.addColumn("users", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("error migrating users table: %w", err)
+		}
+	}
+
+	if _, err := db.addColumn("users", "delete_after", "DATETIME"); err != nil {
+		return fmt.Errorf("error migrating users table: %w", err)
+	}
+
+	if err := db.migrateUserStatus(); err != nil {
+		return fmt.Errorf("error migrating users table: %w", err)
+	}
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS invite_tokens (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			token TEXT UNIQUE NOT NULL,
 			used BOOLEAN NOT NULL DEFAULT 0,
 			created_at DATETIME NOT NULL,
-			used_at DATETIME
+			used_at DATETIME,
+			org_id INTEGER NOT NULL DEFAULT 0,
+			org_role TEXT NOT NULL DEFAULT ''
 		)
 	`)
 	if err != nil {
 		return fmt.Errorf("error creating invite_tokens table: %w", err)
 	}
 
+	if _, err := db.addColumn("invite_tokens", "org_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("error migrating invite_tokens table: %w", err)
+	}
+
+	if _, err := db.addColumn("invite_tokens", "org_role", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error migrating invite_tokens table: %w", err)
+	}
+
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS refresh_tokens (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
 			token TEXT UNIQUE NOT NULL,
+			family_id TEXT NOT NULL DEFAULT '',
 			expires_at DATETIME NOT NULL,
 			created_at DATETIME NOT NULL,
+			rotated_at DATETIME,
+			token_hashed BOOLEAN NOT NULL DEFAULT 0,
+			auth_time DATETIME,
+			scope TEXT NOT NULL DEFAULT '',
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 		)
 	`)
@@ -68,18 +129,387 @@ func (db *DB) Initialize() error {
 		return fmt.Errorf("error creating refresh_tokens table: %w", err)
 	}
 
+	if err := db.migrateRefreshTokenFamilies(); err != nil {
+		return fmt.Errorf("error migrating refresh_tokens table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			device_name TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			last_used_at DATETIME NOT NULL,
+			access_token_id TEXT NOT NULL DEFAULT '',
+			access_expires_at DATETIME NOT NULL DEFAULT 0,
+			org_id INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating sessions table: %w", err)
+	}
+
+	if err := db.migrateSessions(); err != nil {
+		return fmt.Errorf("error migrating sessions table: %w", err)
+	}
+
+	if _, err := db.addColumn("sessions", "org_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("error migrating sessions table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS security_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			ip_address TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating security_events table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating revoked_tokens table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS service_clients (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id TEXT UNIQUE NOT NULL,
+			secret_hash TEXT NOT NULL,
+			name TEXT NOT NULL,
+			audience TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating service_clients table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			data TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_tokens table: %w", err)
+	}
+
+	if _, err := db.addColumn("user_tokens", "data", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error migrating user_tokens table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS mail_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			recipient TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			text_body TEXT NOT NULL,
+			html_body TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			sent_at DATETIME
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating mail_outbox table: %w", err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_mail_outbox_due ON mail_outbox(status, next_attempt_at)")
+	if err != nil {
+		return fmt.Errorf("error creating mail_outbox index: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_roles (
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, role),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_roles table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS roles (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating roles table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS permissions (
+			name TEXT PRIMARY KEY,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating permissions table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS role_permissions (
+			role TEXT NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (role, permission),
+			FOREIGN KEY (role) REFERENCES roles(name) ON DELETE CASCADE,
+			FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating role_permissions table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS organizations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			slug TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating organizations table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS org_memberships (
+			org_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (org_id, user_id),
+			FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating org_memberships table: %w", err)
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_org_memberships_user_id ON org_memberships(user_id)")
+	if err != nil {
+		return fmt.Errorf("error creating org_memberships index: %w", err)
+	}
+
+	if err := db.migrateRoles(); err != nil {
+		return fmt.Errorf("error migrating roles table: %w", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
+// addColumn adds a column to a table created by an earlier version of the
+// schema. It reports whether the column was added.
+func (db *DB) addColumn(table, column, definition string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    bool
+			dfltValue  sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err == nil, err
+}
+
+func (db *DB) migrateUserEmails() error {
+	if _, err := db.addColumn("users", "email", "TEXT"); err != nil {
+		return err
+	}
+
+	if _, err := db.addColumn("users", "email_verified", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// SQLite cannot add a UNIQUE column, and NULLs never collide, so users
+	// without an email are not affected.
+	_, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email)")
+	return err
+}
+
+func (db *DB) migrateUserStatus() error {
+	added, err := db.addColumn("users", "status", "TEXT NOT NULL DEFAULT '"+models.UserStatusActive+"'")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Accounts scheduled for deletion before statuses existed.
+		_, err := db.Exec("UPDATE users SET status = ? WHERE delete_after IS NOT NULL", models.UserStatusPendingDeletion)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.addColumn("users", "status_reason", "TEXT NOT NULL DEFAULT ''")
+	return err
+}
+
+func (db *DB) migrateRefreshTokenFamilies() error {
+	added, err := db.addColumn("refresh_tokens", "family_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Every token issued before families existed starts its own family.
+		if _, err := db.Exec("UPDATE refresh_tokens SET family_id = 'legacy-' || id WHERE family_id = ''"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.addColumn("refresh_tokens", "rotated_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	if _, err := db.addColumn("refresh_tokens", "token_hashed", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	added, err = db.addColumn("refresh_tokens", "auth_time", "DATETIME")
+	if err != nil {
+		return err
+	}
+	if added {
+		// The original login time of older tokens is unknown, the best
+		// estimate is when they were issued.
+		if _, err := db.Exec("UPDATE refresh_tokens SET auth_time = created_at WHERE auth_time IS NULL"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.addColumn("refresh_tokens", "scope", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id)")
+	return err
+}
+
+const userColumns = "id, username, password, email, email_verified, display_name, avatar_url, locale, timezone, status, status_reason, delete_after, created_at, updated_at"
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
+	user := &models.User{}
+	var email sql.NullString
+	var deleteAfter sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Password, &email, &user.EmailVerified,
+		&user.DisplayName, &user.AvatarURL, &user.Locale, &user.Timezone,
+		&user.Status, &user.StatusReason, &deleteAfter, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email = email.String
+	if deleteAfter.Valid {
+		user.DeleteAfter = &deleteAfter.Time
+	}
+	return user, nil
+}
+
+// nullString stores empty strings as NULL, which keeps unset values out of
+// unique indexes.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
 func (db *DB) CreateUser(user *models.User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createUser(tx, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateInvitedUser creates a user and marks the invite token they registered
+// with as used, in one transaction. It returns ErrInviteTokenUsed if the token
+// was used in the meantime.
+func (db *DB) CreateInvitedUser(user *models.User, inviteTokenID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createUser(tx, user); err != nil {
+		return err
+	}
+	if err := useInviteToken(tx, inviteTokenID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createUser(tx *sql.Tx, user *models.User) error {
 	now := time.Now()
 	user.CreatedAt = now
 	user.UpdatedAt = now
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
 
-	result, err := db.Exec(
-		"INSERT INTO users (username, password, created_at, updated_at) VALUES (?, ?, ?, ?)",
-		user.Username, user.Password, user.CreatedAt, user.UpdatedAt,
+	result, err := tx.Exec(
+		"INSERT INTO users (username, password, email, email_verified, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.Username, user.Password, nullString(user.Email), user.EmailVerified, user.Status, user.CreatedAt, user.UpdatedAt,
 	)
 	if err != nil {
 		return err
@@ -95,42 +525,117 @@ func (db *DB) CreateUser(user *models.User) error {
 }
 
 func (db *DB) GetUserByUsername(username string) (*models.User, error) {
-	user := &models.User{}
-	err := db.QueryRow(
-		"SELECT id, username, password, created_at, updated_at FROM users WHERE username = ?",
-		username,
-	).Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func (db *DB) GetUserByID(id int64) (*models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// GetUserByEmail looks a user up by email address. Addresses are stored in
+// lower case.
+func (db *DB) GetUserByEmail(email string) (*models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
+}
+
+// SetUserStatus changes the status of a user and records the reason for it.
+func (db *DB) SetUserStatus(userID int64, status, reason string) error {
+	result, err := db.Exec(
+		"UPDATE users SET status = ?, status_reason = ?, updated_at = ? WHERE id = ?",
+		status, reason, time.Now(), userID,
+	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return user, nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-func (db *DB) GetUserByID(id int64) (*models.User, error) {
-	user := &models.User{}
-	err := db.QueryRow(
-		"SELECT id, username, password, created_at, updated_at FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+// UpdateUserProfile saves the profile fields of a user: display name, avatar
+// URL, locale and timezone.
+func (db *DB) UpdateUserProfile(user *models.User) error {
+	user.UpdatedAt = time.Now()
+	_, err := db.Exec(
+		"UPDATE users SET display_name = ?, avatar_url = ?, locale = ?, timezone = ?, updated_at = ? WHERE id = ?",
+		user.DisplayName, user.AvatarURL, user.Locale, user.Timezone, user.UpdatedAt, user.ID,
+	)
+	return err
+}
+
+// UpdateUserEmail sets the email address of a user and marks it as not
+// verified. An empty email removes it.
+func (db *DB) UpdateUserEmail(userID int64, email string) error {
+	_, err := db.Exec(
+		"UPDATE users SET email = ?, email_verified = 0, updated_at = ? WHERE id = ?",
+		nullString(email), time.Now(), userID,
+	)
+	return err
+}
+
+// MarkUserEmailVerified marks the email of a user as verified if it is still
+// the given address. It reports false if the address has changed since.
+func (db *DB) MarkUserEmailVerified(userID int64, email string) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE users SET email_verified = 1, updated_at = ? WHERE id = ? AND email = ?",
+		time.Now(), userID, email,
+	)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	return user, nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// UpdateUserPassword replaces the password hash of a user.
+func (db *DB) UpdateUserPassword(userID int64, hashedPassword string) error {
+	result, err := db.Exec(
+		"UPDATE users SET password = ?, updated_at = ? WHERE id = ?",
+		hashedPassword, time.Now(), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
 func (db *DB) CreateInviteToken(token string) (*models.InviteToken, error) {
+	return db.CreateOrgInviteToken(token, 0, "")
+}
+
+// CreateOrgInviteToken creates an invite that makes its user a member of an
+// organization with the given role.
+func (db *DB) CreateOrgInviteToken(token string, orgID int64, orgRole string) (*models.InviteToken, error) {
 	now := time.Now()
 	inviteToken := &models.InviteToken{
 		Token:     token,
 		Used:      false,
 		CreatedAt: now,
+		OrgID:     orgID,
+		OrgRole:   orgRole,
 	}
 
 	result, err := db.Exec(
-		"INSERT INTO invite_tokens (token, used, created_at) VALUES (?, ?, ?)",
-		inviteToken.Token, inviteToken.Used, inviteToken.CreatedAt,
+		"INSERT INTO invite_tokens (token, used, created_at, org_id, org_role) VALUES (?, ?, ?, ?, ?)",
+		inviteToken.Token, inviteToken.Used, inviteToken.CreatedAt, inviteToken.OrgID, inviteToken.OrgRole,
 	)
 	if err != nil {
 		return nil, err
@@ -150,9 +655,9 @@ func (db *DB) GetInviteToken(token string) (*models.InviteToken, error) {
 	var usedAt sql.NullTime
 
 	err := db.QueryRow(
-		"SELECT id, token, used, created_at, used_at FROM invite_tokens WHERE token = ?",
+		"SELECT id, token, used, created_at, used_at, org_id, org_role FROM invite_tokens WHERE token = ?",
 		token,
-	).Scan(&inviteToken.ID, &inviteToken.Token, &inviteToken.Used, &inviteToken.CreatedAt, &usedAt)
+	).Scan(&inviteToken.ID, &inviteToken.Token, &inviteToken.Used, &inviteToken.CreatedAt, &usedAt, &inviteToken.OrgID, &inviteToken.OrgRole)
 	if err != nil {
 		return nil, err
 	}
@@ -164,35 +669,109 @@ func (db *DB) GetInviteToken(token string) (*models.InviteToken, error) {
 	return inviteToken, nil
 }
 
-func (db *DB) MarkInviteTokenAsUsed(tokenID int64) error {
+// useInviteToken marks an invite token as used. It returns
+// ErrInviteTokenUsed if it already was.
+func useInviteToken(tx *sql.Tx, tokenID int64) error {
 	now := time.Now()
-	_, err := db.Exec(
-		"UPDATE invite_tokens SET used = 1, used_at = ? WHERE id = ?",
+	result, err := tx.Exec(
+		"UPDATE invite_tokens SET used = 1, used_at = ? WHERE id = ? AND used = 0",
 		now, tokenID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInviteTokenUsed
+	}
+	return nil
 }
 
+// HashRefreshTokens replaces refresh tokens stored in plain text by earlier
+// versions with their keyed hashes. It returns the number of tokens hashed.
+func (db *DB) HashRefreshTokens() (int, error) {
+	if len(db.tokenKey) == 0 {
+		return 0, ErrNoTokenKey
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, token FROM refresh_tokens WHERE token_hashed = 0")
+	if err != nil {
+		return 0, err
+	}
+
+	plain := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain[id] = token
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, token := range plain {
+		tokenHash, err := db.hashToken(token)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE refresh_tokens SET token = ?, token_hashed = 1 WHERE id = ?", tokenHash, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(plain), tx.Commit()
+}
+
+// RefreshToken belongs to a family of tokens rotated from the same login.
+// Rotated tokens are kept until they expire so that replaying one can be
+// detected.
 type RefreshToken struct {
-	ID        int64     `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Token     string     `json:"-"` // keyed hash of the token
+	FamilyID  string     `json:"family_id"`
+	AuthTime  time.Time  `json:"auth_time"`
+	Scope     string     `json:"scope,omitempty"` // granted at login and kept on refresh, empty if unrestricted
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
 }
 
-func (db *DB) CreateRefreshToken(userID int64, token string, expiresAt time.Time) (*RefreshToken, error) {
+func (db *DB) CreateRefreshToken(userID int64, token, familyID, scope string, authTime, expiresAt time.Time) (*RefreshToken, error) {
+	tokenHash, err := db.hashToken(token)
+	if err != nil {
+		return nil, err
+	}
+
 	now := time.Now()
 	refreshToken := &RefreshToken{
 		UserID:    userID,
-		Token:     token,
+		Token:     tokenHash,
+		FamilyID:  familyID,
+		AuthTime:  authTime,
+		Scope:     scope,
 		ExpiresAt: expiresAt,
 		CreatedAt: now,
 	}
 
 	result, err := db.Exec(
-		"INSERT INTO refresh_tokens (user_id, token, expires_at, created_at) VALUES (?, ?, ?, ?)",
-		refreshToken.UserID, refreshToken.Token, refreshToken.ExpiresAt, refreshToken.CreatedAt,
+		"INSERT INTO refresh_tokens (user_id, token, token_hashed, family_id, auth_time, scope, expires_at, created_at) VALUES (?, ?, 1, ?, ?, ?, ?, ?)",
+		refreshToken.UserID, refreshToken.Token, refreshToken.FamilyID, refreshToken.AuthTime, refreshToken.Scope, refreshToken.ExpiresAt, refreshToken.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -208,24 +787,101 @@ func (db *DB) CreateRefreshToken(userID int64, token string, expiresAt time.Time
 }
 
 func (db *DB) GetRefreshToken(token string) (*RefreshToken, error) {
+	tokenHash, err := db.hashToken(token)
+	if err != nil {
+		return nil, err
+	}
+
 	refreshToken := &RefreshToken{}
-	err := db.QueryRow(
-		"SELECT id, user_id, token, expires_at, created_at FROM refresh_tokens WHERE token = ?",
-		token,
-	).Scan(&refreshToken.ID, &refreshToken.UserID, &refreshToken.Token, &refreshToken.ExpiresAt, &refreshToken.CreatedAt)
+	var rotatedAt sql.NullTime
+
+	err = db.QueryRow(
+		"SELECT id, user_id, token, family_id, auth_time, scope, expires_at, created_at, rotated_at FROM refresh_tokens WHERE token = ?",
+		tokenHash,
+	).Scan(&refreshToken.ID, &refreshToken.UserID, &refreshToken.Token, &refreshToken.FamilyID, &refreshToken.AuthTime, &refreshToken.Scope, &refreshToken.ExpiresAt, &refreshToken.CreatedAt, &rotatedAt)
 	if err != nil {
 		return nil, err
 	}
 
+	if rotatedAt.Valid {
+		refreshToken.RotatedAt = &rotatedAt.Time
+	}
+
 	return refreshToken, nil
 }
 
+// MarkRefreshTokenRotated marks a token as used. It reports false if the
+// token had already been rotated, e.g. by a concurrent request.
+func (db *DB) MarkRefreshTokenRotated(tokenID int64) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE refresh_tokens SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL",
+		time.Now(), tokenID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
 func (db *DB) DeleteRefreshToken(token string) error {
-	_, err := db.Exec("DELETE FROM refresh_tokens WHERE token = ?", token)
+	tokenHash, err := db.hashToken(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM refresh_tokens WHERE token = ?", tokenHash)
 	return err
 }
 
+// DeleteRefreshTokenFamily ends a session: the refresh tokens of the family
+// are deleted together with the session it belongs to.
+func (db *DB) DeleteRefreshTokenFamily(familyID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE family_id = ?", familyID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE id = ?", familyID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUserRefreshTokens ends every session of a user.
+func (db *DB) DeleteUserRefreshTokens(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteExpiredRefreshTokens deletes expired refresh tokens and the sessions
+// left without any.
 func (db *DB) DeleteExpiredRefreshTokens() error {
-	_, err := db.Exec("DELETE FROM refresh_tokens WHERE expires_at < datetime('now')")
+	if _, err := db.Exec("DELETE FROM refresh_tokens WHERE expires_at < ?", time.Now()); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("DELETE FROM sessions WHERE id NOT IN (SELECT family_id FROM refresh_tokens)")
 	return err
-} 
\ No newline at end of file
+}
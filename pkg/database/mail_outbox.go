@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+const mailColumns = "id, recipient, subject, text_body, html_body, status, attempts, last_error, next_attempt_at, created_at, sent_at"
+
+func (db *DB) EnqueueMail(mail *models.OutboxMail) error {
+	now := time.Now()
+	mail.Status = models.MailStatusPending
+	mail.NextAttemptAt = now
+	mail.CreatedAt = now
+
+	result, err := db.Exec(
+		"INSERT INTO mail_outbox (recipient, subject, text_body, html_body, status, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		mail.Recipient, mail.Subject, mail.TextBody, mail.HTMLBody, mail.Status, mail.NextAttemptAt, mail.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	mail.ID = id
+	return nil
+}
+
+// GetDueMail returns pending mail whose next attempt is due, oldest first.
+func (db *DB) GetDueMail(now time.Time, limit int) ([]models.OutboxMail, error) {
+	rows, err := db.Query(
+		"SELECT "+mailColumns+" FROM mail_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		models.MailStatusPending, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []models.OutboxMail
+	for rows.Next() {
+		var mail models.OutboxMail
+		var sentAt sql.NullTime
+		err := rows.Scan(
+			&mail.ID, &mail.Recipient, &mail.Subject, &mail.TextBody, &mail.HTMLBody, &mail.Status,
+			&mail.Attempts, &mail.LastError, &mail.NextAttemptAt, &mail.CreatedAt, &sentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if sentAt.Valid {
+			mail.SentAt = &sentAt.Time
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, rows.Err()
+}
+
+// ClaimMail postpones the next attempt of a due mail to until, so that other
+// server instances skip it while it is being sent. It reports false if the
+// mail was claimed by someone else first.
+func (db *DB) ClaimMail(id int64, now, until time.Time) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE mail_outbox SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at <= ?",
+		until, id, models.MailStatusPending, now,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// MarkMailSent records a delivery. The bodies are blanked, since they can
+// hold tokens that are only stored hashed everywhere else.
+func (db *DB) MarkMailSent(id int64) error {
+	_, err := db.Exec(
+		"UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = '', text_body = '', html_body = '', sent_at = ? WHERE id = ?",
+		models.MailStatusSent, time.Now(), id,
+	)
+	return err
+}
+
+// MarkMailFailed records a failed attempt. The mail is retried at
+// nextAttemptAt, or given up on if nextAttemptAt is nil, in which case its
+// bodies are blanked like those of sent mail.
+func (db *DB) MarkMailFailed(id int64, lastError string, nextAttemptAt *time.Time) error {
+	if nextAttemptAt != nil {
+		_, err := db.Exec(
+			"UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?",
+			models.MailStatusPending, lastError, *nextAttemptAt, id,
+		)
+		return err
+	}
+
+	// next_attempt_at records when the mail was given up on
+	_, err := db.Exec(
+		"UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = ?, text_body = '', html_body = '', next_attempt_at = ? WHERE id = ?",
+		models.MailStatusFailed, lastError, time.Now(), id,
+	)
+	return err
+}
+
+// DeleteFinishedMail deletes mail delivered or given up on before the given
+// time.
+func (db *DB) DeleteFinishedMail(before time.Time) error {
+	_, err := db.Exec(
+		"DELETE FROM mail_outbox WHERE (status = ? AND sent_at < ?) OR (status = ? AND next_attempt_at < ?)",
+		models.MailStatusSent, before, models.MailStatusFailed, before,
+	)
+	return err
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// CreateOrganization creates an organization with the given user as its
+// owner.
+func (db *DB) CreateOrganization(org *models.Organization, ownerID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+
+	result, err := tx.Exec(
+		"INSERT INTO organizations (slug, name, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		org.Slug, org.Name, org.CreatedAt, org.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	org.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO org_memberships (org_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		org.ID, ownerID, models.OrgRoleOwner, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetOrganization(id int64) (*models.Organization, error) {
+	org := &models.Organization{}
+	err := db.QueryRow(
+		"SELECT id, slug, name, created_at, updated_at FROM organizations WHERE id = ?",
+		id,
+	).Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// OrganizationSlugTaken reports whether an organization already uses slug.
+func (db *DB) OrganizationSlugTaken(slug string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM organizations WHERE slug = ?", slug).Scan(&count)
+	return count > 0, err
+}
+
+// AcceptOrgInvite makes a user a member of the organization of an invite
+// token, with the role it gives, and marks the token as used, in one
+// transaction. It reports false if the user already was a member, and
+// returns ErrInviteTokenUsed if the token was used in the meantime.
+func (db *DB) AcceptOrgInvite(inviteToken *models.InviteToken, userID int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT OR IGNORE INTO org_memberships (org_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		inviteToken.OrgID, userID, inviteToken.OrgRole, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if err := useInviteToken(tx, inviteToken.ID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (db *DB) GetOrgMembership(orgID, userID int64) (*models.OrgMembership, error) {
+	membership := &models.OrgMembership{}
+	err := db.QueryRow(
+		"SELECT org_id, user_id, role, created_at FROM org_memberships WHERE org_id = ? AND user_id = ?",
+		orgID, userID,
+	).Scan(&membership.OrgID, &membership.UserID, &membership.Role, &membership.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return membership, nil
+}
+
+// ListUserOrganizations returns the memberships of a user together with
+// their organizations, ordered by organization name.
+func (db *DB) ListUserOrganizations(userID int64) ([]*models.OrgMembership, error) {
+	rows, err := db.Query(`
+		SELECT m.org_id, m.user_id, m.role, m.created_at, o.id, o.slug, o.name, o.created_at, o.updated_at
+		FROM org_memberships m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = ?
+		ORDER BY o.name, o.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*models.OrgMembership{}
+	for rows.Next() {
+		membership := &models.OrgMembership{Organization: &models.Organization{}}
+		org := membership.Organization
+		err := rows.Scan(
+			&membership.OrgID, &membership.UserID, &membership.Role, &membership.CreatedAt,
+			&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &org.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+
+// ListOrgMembers returns the members of an organization with their
+// usernames, ordered by username.
+func (db *DB) ListOrgMembers(orgID int64) ([]*models.OrgMembership, error) {
+	rows, err := db.Query(`
+		SELECT m.org_id, m.user_id, m.role, m.created_at, u.username
+		FROM org_memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ?
+		ORDER BY u.username
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*models.OrgMembership{}
+	for rows.Next() {
+		membership := &models.OrgMembership{}
+		err := rows.Scan(&membership.OrgID, &membership.UserID, &membership.Role, &membership.CreatedAt, &membership.Username)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+
+// keepsOwnerCondition matches a membership unless it is the only owner of
+// its organization. Checking it in the same statement as the change keeps
+// two owners from demoting or removing each other at once.
+const keepsOwnerCondition = "(role != '" + models.OrgRoleOwner + "' OR (SELECT COUNT(*) FROM org_memberships o WHERE o.org_id = org_memberships.org_id AND o.role = '" + models.OrgRoleOwner + "') > 1)"
+
+// SetOrgMemberRole changes the role of a member. It reports false if the
+// user is not a member and returns ErrLastOrgOwner if the member is the only
+// owner and would lose that role.
+func (db *DB) SetOrgMemberRole(orgID, userID int64, role string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE org_memberships SET role = ? WHERE org_id = ? AND user_id = ?"
+	if role != models.OrgRoleOwner {
+		query += " AND " + keepsOwnerCondition
+	}
+	result, err := tx.Exec(query, role, orgID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := orgMembershipChanged(tx, result, orgID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RemoveOrgMember takes a user out of an organization and out of the
+// sessions it is active in. It reports false if the user was not a member
+// and returns ErrLastOrgOwner if the user is its only owner.
+func (db *DB) RemoveOrgMember(orgID, userID int64) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM org_memberships WHERE org_id = ? AND user_id = ? AND "+keepsOwnerCondition, orgID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := orgMembershipChanged(tx, result, orgID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, err = tx.Exec("UPDATE sessions SET org_id = 0 WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// orgMembershipChanged checks the result of a change guarded by
+// keepsOwnerCondition. It returns sql.ErrNoRows if the user is not a member
+// and ErrLastOrgOwner if the guard stopped the change.
+func orgMembershipChanged(tx *sql.Tx, result sql.Result, orgID, userID int64) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM org_memberships WHERE org_id = ? AND user_id = ?", orgID, userID).Scan(&role)
+	if err != nil {
+		return err
+	}
+	return ErrLastOrgOwner
+}
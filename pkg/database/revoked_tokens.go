@@ -0,0 +1,43 @@
+package database
+
+import (
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+func (db *DB) RevokeToken(token *models.RevokedToken) error {
+	token.RevokedAt = time.Now()
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO revoked_tokens (jti, user_id, reason, expires_at, revoked_at) VALUES (?, ?, ?, ?, ?)",
+		token.TokenID, token.UserID, token.Reason, token.ExpiresAt, token.RevokedAt,
+	)
+	return err
+}
+
+func (db *DB) GetActiveRevokedTokens() ([]models.RevokedToken, error) {
+	rows, err := db.Query(
+		"SELECT jti, user_id, reason, expires_at, revoked_at FROM revoked_tokens WHERE expires_at > ?",
+		time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.RevokedToken
+	for rows.Next() {
+		var token models.RevokedToken
+		if err := rows.Scan(&token.TokenID, &token.UserID, &token.Reason, &token.ExpiresAt, &token.RevokedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (db *DB) DeleteExpiredRevokedTokens() error {
+	_, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at <= ?", time.Now())
+	return err
+}
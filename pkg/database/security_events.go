@@ -0,0 +1,27 @@
+package database
+
+import (
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+func (db *DB) CreateSecurityEvent(event *models.SecurityEvent) error {
+	event.CreatedAt = time.Now()
+
+	result, err := db.Exec(
+		"INSERT INTO security_events (user_id, type, details, ip_address, created_at) VALUES (?, ?, ?, ?, ?)",
+		event.UserID, event.Type, event.Details, event.IPAddress, event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	event.ID = id
+	return nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+func (db *DB) CreateServiceClient(client *models.ServiceClient) error {
+	client.CreatedAt = time.Now()
+
+	result, err := db.Exec(
+		"INSERT INTO service_clients (client_id, secret_hash, name, audience, created_at) VALUES (?, ?, ?, ?, ?)",
+		client.ClientID, client.SecretHash, client.Name, client.Audience, client.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	client.ID = id
+	return nil
+}
+
+func (db *DB) GetServiceClient(clientID string) (*models.ServiceClient, error) {
+	client := &models.ServiceClient{}
+	err := db.QueryRow(
+		"SELECT id, client_id, secret_hash, name, audience, created_at FROM service_clients WHERE client_id = ?",
+		clientID,
+	).Scan(&client.ID, &client.ClientID, &client.SecretHash, &client.Name, &client.Audience, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (db *DB) ListServiceClients() ([]models.ServiceClient, error) {
+	rows, err := db.Query("SELECT id, client_id, secret_hash, name, audience, created_at FROM service_clients ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []models.ServiceClient
+	for rows.Next() {
+		var client models.ServiceClient
+		if err := rows.Scan(&client.ID, &client.ClientID, &client.SecretHash, &client.Name, &client.Audience, &client.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+func (db *DB) DeleteServiceClient(clientID string) (bool, error) {
+	result, err := db.Exec("DELETE FROM service_clients WHERE client_id = ?", clientID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+const sessionColumns = "id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, access_token_id, access_expires_at, org_id"
+
+func (db *DB) migrateSessions() error {
+	// Logins made before sessions were tracked get a session without
+	// device details, so that they can still be listed and revoked.
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO sessions (id, user_id, created_at, last_used_at)
+		SELECT family_id, user_id, MIN(auth_time), MAX(created_at)
+		FROM refresh_tokens
+		GROUP BY family_id
+	`)
+	return err
+}
+
+func (db *DB) CreateSession(session *models.Session) error {
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+
+	_, err := db.Exec(
+		"INSERT INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		session.ID, session.UserID, session.DeviceName, session.UserAgent, session.IPAddress,
+		session.CreatedAt, session.LastUsedAt, session.AccessTokenID, session.AccessExpiresAt, session.OrgID,
+	)
+	return err
+}
+
+// UpdateSessionActivity records a refresh of the session from the given
+// client and the access token issued for it.
+func (db *DB) UpdateSessionActivity(id, userAgent, ipAddress, accessTokenID string, accessExpiresAt time.Time) error {
+	_, err := db.Exec(
+		"UPDATE sessions SET user_agent = ?, ip_address = ?, last_used_at = ?, access_token_id = ?, access_expires_at = ? WHERE id = ?",
+		userAgent, ipAddress, time.Now(), accessTokenID, accessExpiresAt, id,
+	)
+	return err
+}
+
+// SetSessionOrg changes the organization active in a session, 0 for none.
+func (db *DB) SetSessionOrg(id string, orgID int64) error {
+	_, err := db.Exec("UPDATE sessions SET org_id = ? WHERE id = ?", orgID, id)
+	return err
+}
+
+func (db *DB) GetSession(id string) (*models.Session, error) {
+	row := db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	session := &models.Session{}
+	if err := scanSession(row, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// ListUserSessions returns the sessions of a user, most recently used first.
+func (db *DB) ListUserSessions(userID int64) ([]models.Session, error) {
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? ORDER BY last_used_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// ListOrgSessions returns the sessions of a user in which an organization is
+// active.
+func (db *DB) ListOrgSessions(orgID, userID int64) ([]models.Session, error) {
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func scanSession(row interface{ Scan(...any) error }, session *models.Session) error {
+	return row.Scan(
+		&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastUsedAt, &session.AccessTokenID, &session.AccessExpiresAt, &session.OrgID,
+	)
+}
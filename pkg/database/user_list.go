@@ -0,0 +1,82 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// UserFilter selects users for ListUsers. Zero fields do not filter.
+type UserFilter struct {
+	// UsernamePrefix matches usernames starting with it.
+	UsernamePrefix string
+	Status         string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	Limit          int
+	Offset         int
+}
+
+// ListUsers returns one page of the users matching filter, ordered by ID,
+// and the number of all matching users.
+func (db *DB) ListUsers(filter UserFilter) ([]*models.User, int, error) {
+	var conditions []string
+	var args []any
+	if filter.UsernamePrefix != "" {
+		conditions = append(conditions, `username LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(filter.UsernamePrefix)+"%")
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	// created_at is stored as text in the server's local time and compared
+	// as a string, so the bounds must be in the same zone
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.CreatedAfter.In(time.Local))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.CreatedBefore.In(time.Local))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		"SELECT "+userColumns+" FROM users"+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, filter.Limit, filter.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
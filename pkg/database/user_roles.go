@@ -0,0 +1,314 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// migrateRoles makes sure the admin role exists, as well as every role
+// assigned before roles had to be created first.
+func (db *DB) migrateRoles() error {
+	now := time.Now()
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO roles (name, description, created_at) VALUES (?, ?, ?)",
+		models.RoleAdmin, "Access to the admin API", now,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT OR IGNORE INTO roles (name, created_at) SELECT DISTINCT role, ? FROM user_roles",
+		now,
+	)
+	return err
+}
+
+// CreateRole adds a role. It reports false if the role already exists.
+func (db *DB) CreateRole(name, description string) (bool, error) {
+	result, err := db.Exec(
+		"INSERT OR IGNORE INTO roles (name, description, created_at) VALUES (?, ?, ?)",
+		name, description, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// DeleteRole deletes a role and takes it away from every user. It returns
+// the IDs of the users who held it and reports false if there was no such
+// role.
+func (db *DB) DeleteRole(name string) ([]int64, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// The first write locks the database, so nobody can be granted the role
+	// while its holders are collected.
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = ?", name); err != nil {
+		return nil, false, err
+	}
+	userIDs, err := roleUserIDs(tx, name)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE role = ?", name); err != nil {
+		return nil, false, err
+	}
+
+	result, err := tx.Exec("DELETE FROM roles WHERE name = ?", name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	return userIDs, affected > 0, tx.Commit()
+}
+
+// roleUserIDs returns the IDs of the users holding a role.
+func roleUserIDs(tx *sql.Tx, role string) ([]int64, error) {
+	rows, err := tx.Query("SELECT user_id FROM user_roles WHERE role = ? ORDER BY user_id", role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// ListRoles returns all roles with their permissions and the number of
+// users holding them.
+func (db *DB) ListRoles() ([]*models.Role, error) {
+	rows, err := db.Query(`
+		SELECT r.name, r.description, r.created_at, (SELECT COUNT(*) FROM user_roles ur WHERE ur.role = r.name)
+		FROM roles r ORDER BY r.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []*models.Role{}
+	byName := make(map[string]*models.Role)
+	for rows.Next() {
+		role := &models.Role{Permissions: []string{}}
+		if err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt, &role.Users); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles = append(roles, role)
+		byName[role.Name] = role
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT role, permission FROM role_permissions ORDER BY permission")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, permission string
+		if err := rows.Scan(&name, &permission); err != nil {
+			return nil, err
+		}
+		if role, ok := byName[name]; ok {
+			role.Permissions = append(role.Permissions, permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// AddRolePermission grants a permission to a role, creating the permission
+// if it is new. It returns sql.ErrNoRows if there is no such role and
+// reports false if the role already had the permission.
+func (db *DB) AddRolePermission(role, permission string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := roleExists(tx, role); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO permissions (name, created_at) VALUES (?, ?)", permission, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", role, permission)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, tx.Commit()
+}
+
+// RemoveRolePermission takes a permission away from a role. It returns the
+// IDs of the users holding the role and reports false if the role did not
+// have the permission.
+func (db *DB) RemoveRolePermission(role, permission string) ([]int64, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM role_permissions WHERE role = ? AND permission = ?", role, permission)
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if affected == 0 {
+		return nil, false, nil
+	}
+
+	userIDs, err := roleUserIDs(tx, role)
+	if err != nil {
+		return nil, false, err
+	}
+	return userIDs, true, tx.Commit()
+}
+
+// AddUserRole grants a role to a user. It returns sql.ErrNoRows if there is
+// no such role and reports false if the user already had it.
+func (db *DB) AddUserRole(userID int64, role string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := roleExists(tx, role); err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(
+		"INSERT OR IGNORE INTO user_roles (user_id, role, created_at) VALUES (?, ?, ?)",
+		userID, role, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, tx.Commit()
+}
+
+// RemoveUserRole takes a role away from a user. It reports false if the user
+// did not have it.
+func (db *DB) RemoveUserRole(userID int64, role string) (bool, error) {
+	result, err := db.Exec("DELETE FROM user_roles WHERE user_id = ? AND role = ?", userID, role)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// LoadUserRoles fills in the roles of the given users and the permissions
+// these roles grant, with one query each.
+func (db *DB) LoadUserRoles(users ...*models.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*models.User, len(users))
+	args := make([]any, 0, len(users))
+	for _, user := range users {
+		user.Roles = []string{}
+		user.Permissions = []string{}
+		byID[user.ID] = user
+		args = append(args, user.ID)
+	}
+	in := "(?" + strings.Repeat(", ?", len(args)-1) + ")"
+
+	rows, err := db.Query("SELECT user_id, role FROM user_roles WHERE user_id IN "+in+" ORDER BY role", args...)
+	if err != nil {
+		return err
+	}
+	err = scanUserStrings(rows, byID, func(user *models.User, role string) {
+		user.Roles = append(user.Roles, role)
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(`
+		SELECT DISTINCT ur.user_id, rp.permission
+		FROM user_roles ur JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id IN `+in+` ORDER BY rp.permission`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	return scanUserStrings(rows, byID, func(user *models.User, permission string) {
+		user.Permissions = append(user.Permissions, permission)
+	})
+}
+
+// scanUserStrings reads (user_id, value) rows and hands each value to add
+// together with its user.
+func scanUserStrings(rows *sql.Rows, byID map[int64]*models.User, add func(*models.User, string)) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var value string
+		if err := rows.Scan(&userID, &value); err != nil {
+			return err
+		}
+		if user, ok := byID[userID]; ok {
+			add(user, value)
+		}
+	}
+	return rows.Err()
+}
+
+// roleExists returns sql.ErrNoRows if there is no role with the given name.
+func roleExists(tx *sql.Tx, name string) error {
+	var found string
+	return tx.QueryRow("SELECT name FROM roles WHERE name = ?", name).Scan(&found)
+}
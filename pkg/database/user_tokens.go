@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// CreateUserToken stores a single-use token for a user. Unused tokens issued
+// earlier for the same purpose are deleted, so only the latest one works.
+func (db *DB) CreateUserToken(userID int64, purpose, token, data string, expiresAt time.Time) (*models.UserToken, error) {
+	tokenHash, err := db.hashToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userToken := &models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		Data:      data,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO user_tokens (user_id, purpose, token_hash, data, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userToken.UserID, userToken.Purpose, userToken.TokenHash, userToken.Data, userToken.ExpiresAt, userToken.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	userToken.ID = id
+	return userToken, nil
+}
+
+func (db *DB) GetUserToken(purpose, token string) (*models.UserToken, error) {
+	tokenHash, err := db.hashToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userToken := &models.UserToken{}
+	var usedAt sql.NullTime
+
+	err = db.QueryRow(
+		"SELECT id, user_id, purpose, token_hash, data, expires_at, created_at, used_at FROM user_tokens WHERE purpose = ? AND token_hash = ?",
+		purpose, tokenHash,
+	).Scan(&userToken.ID, &userToken.UserID, &userToken.Purpose, &userToken.TokenHash, &userToken.Data, &userToken.ExpiresAt, &userToken.CreatedAt, &usedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		userToken.UsedAt = &usedAt.Time
+	}
+
+	return userToken, nil
+}
+
+// MarkUserTokenUsed marks a token as used. It reports false if the token had
+// already been used, e.g. by a concurrent request.
+func (db *DB) MarkUserTokenUsed(tokenID int64) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE user_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL",
+		time.Now(), tokenID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// DeleteExpiredUserTokens deletes tokens that can no longer be used.
+func (db *DB) DeleteExpiredUserTokens() error {
+	_, err := db.Exec("DELETE FROM user_tokens WHERE expires_at < ?", time.Now())
+	return err
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// DeleteAccount schedules the caller's account for deletion after the grace
+// period and ends all of their sessions. Logging in again before the
+// deletion is due cancels it.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := auth.CheckPassword(req.Password, user.Password); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	// Отключённый или заблокированный аккаунт удалить сам себя не может
+	if msg := accountBlocked(user); msg != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return
+	}
+
+	if !h.canDeleteUser(c, user) {
+		return
+	}
+
+	deleteAfter := time.Now().Add(h.AccountDeletionGrace)
+	if user.Status == models.UserStatusPendingDeletion && user.DeleteAfter != nil {
+		deleteAfter = *user.DeleteAfter
+	} else {
+		scheduled, err := h.DB.ScheduleUserDeletion(user.ID, deleteAfter)
+		if err != nil {
+			log.Printf("Error scheduling account deletion: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if !scheduled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+			return
+		}
+	}
+	log.Printf("User %d scheduled for deletion at %s", user.ID, deleteAfter.Format(time.RFC3339))
+
+	if err := h.endAllSessions(user.ID, "account deletion"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.Denylist.Revoke(claims, "account deletion"); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"delete_after": deleteAfter})
+}
+
+// canDeleteUser turns away the deletion of a user who is the last owner of
+// an organization, which would leave it without an owner.
+func (h *AuthHandler) canDeleteUser(c *gin.Context, user *models.User) bool {
+	last, err := h.DB.IsLastOrgOwner(user.ID)
+	if err != nil {
+		log.Printf("Error checking organization owners: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return false
+	}
+	if last {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is the last owner of an organization"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/models"
+)
+
+type CreateRoleRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description" binding:"max=256"`
+}
+
+// AdminListRoles returns all roles with their permissions.
+func (h *AuthHandler) AdminListRoles(c *gin.Context) {
+	roles, err := h.DB.ListRoles()
+	if err != nil {
+		log.Printf("Error listing roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// AdminGrantRole gives a role to a user. It takes effect with the next login
+// or token refresh of the user.
+func (h *AuthHandler) AdminGrantRole(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+	added, err := h.DB.AddUserRole(user.ID, role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		log.Printf("Error granting role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if added {
+		log.Printf("Role %s granted to user %d by %s", role, user.ID, adminName(c))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AdminRevokeRole takes a role away from a user and ends all of the user's
+// sessions, since their access tokens still carry the role.
+func (h *AuthHandler) AdminRevokeRole(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+	removed, err := h.DB.RemoveUserRole(user.ID, role)
+	if err != nil {
+		log.Printf("Error revoking role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this role"})
+		return
+	}
+
+	if err := h.endAllSessions(user.ID, "role "+role+" revoked"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("Role %s revoked from user %d by %s", role, user.ID, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// AdminCreateRole adds a role.
+func (h *AuthHandler) AdminCreateRole(c *gin.Context) {
+	var req CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !models.RoleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	created, err := h.DB.CreateRole(req.Name, req.Description)
+	if err != nil {
+		log.Printf("Error creating role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role already exists"})
+		return
+	}
+
+	log.Printf("Role %s created by %s", req.Name, adminName(c))
+	c.Status(http.StatusCreated)
+}
+
+// AdminDeleteRole deletes a role, takes it away from every user and ends all
+// sessions of its former holders.
+func (h *AuthHandler) AdminDeleteRole(c *gin.Context) {
+	role := c.Param("role")
+	if role == models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Role cannot be deleted"})
+		return
+	}
+
+	userIDs, deleted, err := h.DB.DeleteRole(role)
+	if err != nil {
+		log.Printf("Error deleting role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	if err := h.endRoleSessions(userIDs, "role "+role+" deleted"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("Role %s deleted by %s", role, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// AdminGrantPermission adds a permission to a role. It takes effect with the
+// next login or token refresh of the role's users.
+func (h *AuthHandler) AdminGrantPermission(c *gin.Context) {
+	role, permission := c.Param("role"), c.Param("permission")
+	if !models.RoleNamePattern.MatchString(permission) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission name"})
+		return
+	}
+
+	added, err := h.DB.AddRolePermission(role, permission)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		log.Printf("Error granting permission: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if added {
+		log.Printf("Permission %s granted to role %s by %s", permission, role, adminName(c))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AdminRevokePermission takes a permission away from a role and ends all
+// sessions of the role's users, since their access tokens still carry it.
+func (h *AuthHandler) AdminRevokePermission(c *gin.Context) {
+	role, permission := c.Param("role"), c.Param("permission")
+
+	userIDs, removed, err := h.DB.RemoveRolePermission(role, permission)
+	if err != nil {
+		log.Printf("Error revoking permission: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role does not have this permission"})
+		return
+	}
+
+	if err := h.endRoleSessions(userIDs, "permission "+permission+" revoked from role "+role); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("Permission %s revoked from role %s by %s", permission, role, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// endRoleSessions ends all sessions of the given users, whose access tokens
+// still carry a role or permission they lost.
+func (h *AuthHandler) endRoleSessions(userIDs []int64, reason string) error {
+	for _, userID := range userIDs {
+		if err := h.endAllSessions(userID, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/database"
+	"github.com/user/user-server/pkg/models"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 100
+)
+
+type AdminListUsersRequest struct {
+	// Username matches usernames starting with it.
+	Username      string    `form:"username"`
+	Status        string    `form:"status" binding:"omitempty,oneof=active disabled locked pending_deletion"`
+	CreatedAfter  time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit         int       `form:"limit" binding:"min=0,max=100"`
+	Offset        int       `form:"offset" binding:"min=0"`
+}
+
+type AdminListUsersResponse struct {
+	Users  []*models.User `json:"users"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// AdminUpdateUserRequest holds the fields to change. Omitted fields are left
+// as they are. Changing the email address marks it unverified unless
+// email_verified is given as well.
+type AdminUpdateUserRequest struct {
+	UpdateProfileRequest
+	Email         *string `json:"email" binding:"omitempty,email,max=254"`
+	EmailVerified *bool   `json:"email_verified"`
+}
+
+type AdminDisableUserRequest struct {
+	Reason string `json:"reason" binding:"max=256"`
+	// Lock marks the account locked for security reasons instead of
+	// disabled.
+	Lock bool `json:"lock"`
+}
+
+// AdminListUsers returns one page of users, optionally filtered by username
+// prefix, status and creation time.
+func (h *AuthHandler) AdminListUsers(c *gin.Context) {
+	var req AdminListUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultUserPageSize
+	}
+
+	users, total, err := h.DB.ListUsers(database.UserFilter{
+		UsernamePrefix: req.Username,
+		Status:         req.Status,
+		CreatedAfter:   req.CreatedAfter,
+		CreatedBefore:  req.CreatedBefore,
+		Limit:          req.Limit,
+		Offset:         req.Offset,
+	})
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.DB.LoadUserRoles(users...); err != nil {
+		log.Printf("Error getting roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, AdminListUsersResponse{
+		Users:  users,
+		Total:  total,
+		Limit:  req.Limit,
+		Offset: req.Offset,
+	})
+}
+
+func (h *AuthHandler) AdminGetUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.LoadUserRoles(user); err != nil {
+		log.Printf("Error getting roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *AuthHandler) AdminUpdateUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	var req AdminUpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if msg := req.apply(user); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	email, verified := user.Email, user.EmailVerified
+	if req.Email != nil && normalizeEmail(*req.Email) != user.Email {
+		email, verified = normalizeEmail(*req.Email), false
+
+		if taken, err := h.emailTaken(email); err != nil {
+			log.Printf("Error checking email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		} else if taken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User with this email already exists"})
+			return
+		}
+	}
+	if req.EmailVerified != nil {
+		verified = *req.EmailVerified
+	}
+	if email == "" && verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User has no email to verify"})
+		return
+	}
+
+	if err := h.DB.UpdateUserProfile(user); err != nil {
+		log.Printf("Error updating profile: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if email != user.Email || verified != user.EmailVerified {
+		if err := h.DB.UpdateUserEmail(user.ID, email); err != nil {
+			log.Printf("Error updating email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if verified {
+			if _, err := h.DB.MarkUserEmailVerified(user.ID, email); err != nil {
+				log.Printf("Error marking email verified: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+		}
+		user.Email, user.EmailVerified = email, verified
+	}
+
+	if err := h.DB.LoadUserRoles(user); err != nil {
+		log.Printf("Error getting roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("User %d updated by %s", user.ID, adminName(c))
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminDisableUser disables or locks an account and ends all of its
+// sessions.
+func (h *AuthHandler) AdminDisableUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	var req AdminDisableUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if isCurrentUser(c, user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot disable your own account"})
+		return
+	}
+	if user.Status == models.UserStatusPendingDeletion {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is scheduled for deletion"})
+		return
+	}
+
+	status := models.UserStatusDisabled
+	if req.Lock {
+		status = models.UserStatusLocked
+	}
+	if err := h.DB.SetUserStatus(user.ID, status, req.Reason); err != nil {
+		log.Printf("Error updating user status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.endAllSessions(user.ID, "account "+status); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("User %d %s by %s", user.ID, status, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// AdminEnableUser makes a disabled or locked account active again.
+func (h *AuthHandler) AdminEnableUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	// Удаление отменяет только сам пользователь, войдя в аккаунт
+	if user.Status == models.UserStatusPendingDeletion {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is scheduled for deletion"})
+		return
+	}
+
+	if err := h.DB.SetUserStatus(user.ID, models.UserStatusActive, ""); err != nil {
+		log.Printf("Error updating user status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("User %d enabled by %s", user.ID, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// AdminDeleteUser deletes an account right away, without a grace period.
+func (h *AuthHandler) AdminDeleteUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	if isCurrentUser(c, user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete your own account"})
+		return
+	}
+
+	if !h.canDeleteUser(c, user) {
+		return
+	}
+
+	// Сначала завершаем сессии, чтобы выданные access токены попали в
+	// denylist
+	if err := h.endAllSessions(user.ID, "account deleted"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if _, err := h.DB.DeleteUser(user.ID); err != nil {
+		if errors.Is(err, database.ErrLastOrgOwner) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is the last owner of an organization"})
+			return
+		}
+		log.Printf("Error deleting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("User %d deleted by %s", user.ID, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// AdminLogoutUser ends all sessions of a user.
+func (h *AuthHandler) AdminLogoutUser(c *gin.Context) {
+	user, ok := h.adminUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.endAllSessions(user.ID, "logged out by admin"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	log.Printf("User %d logged out by %s", user.ID, adminName(c))
+	c.Status(http.StatusNoContent)
+}
+
+// adminUser loads the user named by the :id path parameter. It writes the
+// error response and reports false if there is no such user.
+func (h *AuthHandler) adminUser(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	user, err := h.DB.GetUserByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	return user, true
+}
+
+// isCurrentUser reports whether an admin request is about the admin's own
+// account.
+func isCurrentUser(c *gin.Context, user *models.User) bool {
+	claims, ok := c.Get("claims")
+	return ok && claims.(*auth.Claims).UserID == user.ID
+}
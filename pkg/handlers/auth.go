@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -12,36 +13,90 @@ import (
 	"github.com/user/user-server/pkg/auth"
 	"github.com/user/user-server/pkg/database"
 	"github.com/user/user-server/pkg/models"
+	"github.com/user/user-server/pkg/notify"
 )
 
 type AuthHandler struct {
-	DB         *database.DB
-	JWTManager *auth.JWTManager
+	DB          *database.DB
+	JWTManager  *auth.JWTManager
+	Denylist    *auth.Denylist
+	AdminAPIKey string
+	// RefreshTokenTTL is how long a refresh token stays valid after it was
+	// issued; every refresh slides it forward.
+	RefreshTokenTTL time.Duration
+	// SessionMaxAge caps a session measured from the original login, no
+	// matter how often it is refreshed. Zero means no limit.
+	SessionMaxAge time.Duration
+	// SessionIdleTimeout ends a session that has not been refreshed for
+	// this long. Zero means no limit beyond RefreshTokenTTL.
+	SessionIdleTimeout time.Duration
+	// Notifier delivers password reset and email verification tokens.
+	Notifier notify.Notifier
+	// PasswordResetTTL is how long a password reset token stays valid.
+	PasswordResetTTL time.Duration
+	// PasswordResetURL is the page password reset links point to, with the
+	// token added as a query parameter. Empty means only the bare token is
+	// sent.
+	PasswordResetURL string
+	// RequireEmail makes the email address mandatory on registration.
+	RequireEmail bool
+	// EmailVerificationTTL is how long an email verification token stays
+	// valid.
+	EmailVerificationTTL time.Duration
+	// EmailVerificationURL is the page verification links point to, with
+	// the token added as a query parameter.
+	EmailVerificationURL string
+	// AccountDeletionGrace is how long a deleted account can still be
+	// restored by logging in.
+	AccountDeletionGrace time.Duration
+	// EnforceUserStatus makes AuthMiddleware look up the user on every
+	// request and reject access tokens of accounts that are no longer
+	// active. Without it such tokens keep working until they expire.
+	EnforceUserStatus bool
+	// Scopes lists the scopes clients may ask for. A login without a
+	// requested scope gets all of them. Empty means clients may ask for any
+	// scope and tokens are unrestricted by default.
+	Scopes []string
 }
 
 type RegisterRequest struct {
 	Username     string `json:"username" binding:"required,min=3,max=32"`
 	Password  string `json:"password" binding:"required,min=8"`
 	InviteToken string `json:"invite_token" binding:"required"`
+	Email       string `json:"email" binding:"omitempty,email,max=254"`
 }
 
 type LoginRequest struct {
 	Username    string `json:"username" binding:"required,min=3,max=32"`
 	Password string `json:"password" binding:"required"`
+	// DeviceName is an optional label shown in the session list.
+	DeviceName string `json:"device_name" binding:"max=64"`
+	// Scope is an optional space-separated list of scopes to limit the
+	// tokens to.
+	Scope string `json:"scope" binding:"max=1024"`
+	// OrgID optionally makes an organization of the user active in the
+	// new session.
+	OrgID int64 `json:"org_id" binding:"min=0"`
 }
 
 type TokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	Scope            string `json:"scope,omitempty"`
 }
 
 type PublicKeyResponse struct {
-	Key string `json:"key"`
+	Key   string `json:"key"`
+	KeyID string `json:"kid"`
 }
 
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
+	// Scope optionally narrows the new access token to part of the scope
+	// granted at login.
+	Scope string `json:"scope" binding:"max=1024"`
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -62,6 +117,13 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Приглашения в организацию выдают сами пользователи, поэтому они
+	// годятся только для вступления существующих аккаунтов
+	if inviteToken.OrgID != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite token"})
+		return
+	}
+
 	if inviteToken.Used {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invite token already used"})
 		return
@@ -77,6 +139,22 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	email := normalizeEmail(req.Email)
+	if email == "" && h.RequireEmail {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+		return
+	}
+	if email != "" {
+		if taken, err := h.emailTaken(email); err != nil {
+			log.Printf("Error checking email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		} else if taken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User with this email already exists"})
+			return
+		}
+	}
+
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
@@ -87,39 +165,36 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	user := &models.User{
 		Username:    req.Username,
 		Password: hashedPassword,
+		Email:    email,
 	}
 
-	if err := h.DB.CreateUser(user); err != nil {
+	if err := h.DB.CreateInvitedUser(user, inviteToken.ID); err != nil {
+		if errors.Is(err, database.ErrInviteTokenUsed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invite token already used"})
+			return
+		}
 		log.Printf("Error creating user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	if err := h.DB.MarkInviteTokenAsUsed(inviteToken.ID); err != nil {
-		log.Printf("Error marking token as used: %v", err)
-	}
-
-	accessToken, refreshToken, err := h.JWTManager.GenerateTokenPair(user.ID, user.Username)
-	if err != nil {
-		log.Printf("Error generating tokens: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
+	// Регистрация не должна срываться из-за доставки письма, токен можно
+	// запросить повторно
+	if user.Email != "" {
+		if err := h.sendEmailVerification(user); err != nil {
+			log.Printf("Error sending email verification: %v", err)
+		}
 	}
 
-	// Сохраняем refresh токен в базе данных
-	refreshTokenExpiresAt := time.Now().Add(30 * 24 * time.Hour) // 30 дней
-	_, err = h.DB.CreateRefreshToken(user.ID, refreshToken, refreshTokenExpiresAt)
+	scope, _ := h.grantScope("", "")
+	resp, err := h.startSession(c, user, "", scope, 0)
 	if err != nil {
-		log.Printf("Error saving refresh token: %v", err)
+		log.Printf("Error issuing tokens: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(h.JWTManager.GetTokenTTL().Seconds()),
-	})
+	c.JSON(http.StatusCreated, resp)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -129,6 +204,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	scope, ok := h.grantScope(req.Scope, "")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
 	user, err := h.DB.GetUserByUsername(req.Username)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -145,27 +226,44 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.JWTManager.GenerateTokenPair(user.ID, user.Username)
-	if err != nil {
-		log.Printf("Error generating tokens: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	// Статус проверяем только после пароля, чтобы не раскрывать его
+	// посторонним
+	if msg := accountBlocked(user); msg != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
 		return
 	}
 
-	// Сохраняем refresh токен в базе данных
-	refreshTokenExpiresAt := time.Now().Add(30 * 24 * time.Hour) // 30 дней
-	_, err = h.DB.CreateRefreshToken(user.ID, refreshToken, refreshTokenExpiresAt)
+	if req.OrgID != 0 {
+		if _, err := h.DB.GetOrgMembership(req.OrgID, user.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this organization"})
+				return
+			}
+			log.Printf("Error getting membership: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+
+	// Вход в течение льготного периода отменяет удаление аккаунта
+	if user.DeleteAfter != nil {
+		if _, err := h.DB.CancelUserDeletion(user.ID); err != nil {
+			log.Printf("Error canceling account deletion: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		user.DeleteAfter = nil
+		log.Printf("Deletion of user %d canceled by login", user.ID)
+	}
+
+	resp, err := h.startSession(c, user, req.DeviceName, scope, req.OrgID)
 	if err != nil {
-		log.Printf("Error saving refresh token: %v", err)
+		log.Printf("Error issuing tokens: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	c.JSON(http.StatusOK, TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(h.JWTManager.GetTokenTTL().Seconds()),
-	})
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *AuthHandler) Refresh(c *gin.Context) {
@@ -175,6 +273,13 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
+	h.refresh(c, &req, nil)
+}
+
+// refresh rotates the refresh token of req and issues new tokens. If orgID
+// is given, the session switches to that organization first, or to none if
+// it is 0.
+func (h *AuthHandler) refresh(c *gin.Context, req *RefreshRequest, orgID *int64) {
 	refreshToken, err := h.DB.GetRefreshToken(req.RefreshToken)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -186,11 +291,26 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
+	if refreshToken.RotatedAt != nil {
+		h.handleRefreshTokenReuse(c, refreshToken)
+		return
+	}
+
 	if time.Now().After(refreshToken.ExpiresAt) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
 		return
 	}
 
+	// Лимиты проверяются и здесь, на случай если их ужесточили уже после
+	// выдачи токена
+	if h.sessionExpired(refreshToken) {
+		if err := h.endSession(refreshToken.FamilyID, "session expired"); err != nil {
+			log.Printf("Error deleting expired session: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
 	user, err := h.DB.GetUserByID(refreshToken.UserID)
 	if err != nil {
 		log.Printf("Error getting user: %v", err)
@@ -198,73 +318,287 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	accessToken, newRefreshToken, err := h.JWTManager.GenerateTokenPair(user.ID, user.Username)
+	if user.Status != models.UserStatusActive {
+		if err := h.endSession(refreshToken.FamilyID, "account "+user.Status); err != nil {
+			log.Printf("Error deleting session of inactive account: %v", err)
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": inactiveAccountError(user)})
+		return
+	}
+
+	// Refresh токен не может расширить доступ, выданный при входе
+	scope, ok := h.grantScope(req.Scope, refreshToken.Scope)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
+	if orgID != nil && *orgID != 0 {
+		if _, err := h.DB.GetOrgMembership(*orgID, user.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this organization"})
+				return
+			}
+			log.Printf("Error getting membership: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+
+	// Помечаем старый refresh токен как использованный; он остаётся в базе
+	// до истечения срока, чтобы распознать его повторное предъявление
+	rotated, err := h.DB.MarkRefreshTokenRotated(refreshToken.ID)
 	if err != nil {
-		log.Printf("Error generating tokens: %v", err)
+		log.Printf("Error rotating refresh token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
+	if !rotated {
+		h.handleRefreshTokenReuse(c, refreshToken)
+		return
+	}
 
-	// Удаляем старый refresh токен
-	if err := h.DB.DeleteRefreshToken(req.RefreshToken); err != nil {
-		log.Printf("Error deleting old refresh token: %v", err)
+	if orgID != nil {
+		if err := h.DB.SetSessionOrg(refreshToken.FamilyID, *orgID); err != nil {
+			log.Printf("Error switching organization: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
 	}
 
-	// Сохраняем новый refresh токен
-	refreshTokenExpiresAt := time.Now().Add(30 * 24 * time.Hour) // 30 дней
-	_, err = h.DB.CreateRefreshToken(user.ID, newRefreshToken, refreshTokenExpiresAt)
+	// Новый refresh токен остаётся в том же семействе
+	resp, err := h.issueTokens(c, user, refreshToken.FamilyID, refreshToken.AuthTime, refreshToken.Scope, scope)
 	if err != nil {
-		log.Printf("Error saving new refresh token: %v", err)
+		log.Printf("Error issuing tokens: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	c.JSON(http.StatusOK, TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-		ExpiresIn:    int64(h.JWTManager.GetTokenTTL().Seconds()),
-	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// accountBlocked returns the error shown when the user may not log in, or an
+// empty string if they may. Accounts pending deletion can log in, which
+// cancels the deletion.
+func accountBlocked(user *models.User) string {
+	switch user.Status {
+	case models.UserStatusActive, models.UserStatusPendingDeletion:
+		return ""
+	default:
+		return inactiveAccountError(user)
+	}
+}
+
+// inactiveAccountError describes why a user that is not active was turned
+// away.
+func inactiveAccountError(user *models.User) string {
+	switch user.Status {
+	case models.UserStatusLocked:
+		return "Account locked"
+	case models.UserStatusPendingDeletion:
+		return "Account scheduled for deletion"
+	default:
+		return "Account disabled"
+	}
+}
+
+// startSession records a session for a fresh login, with orgID active unless
+// it is 0, and opens the refresh token family that shares its ID, with scope
+// granted to the whole family.
+func (h *AuthHandler) startSession(c *gin.Context, user *models.User, deviceName, scope string, orgID int64) (*TokenResponse, error) {
+	familyID, err := auth.GenerateTokenID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating token family ID: %w", err)
+	}
+
+	session := &models.Session{
+		ID:         familyID,
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		OrgID:      orgID,
+	}
+	if err := h.DB.CreateSession(session); err != nil {
+		return nil, fmt.Errorf("error saving session: %w", err)
+	}
+
+	return h.issueTokens(c, user, familyID, session.CreatedAt, scope, scope)
+}
+
+// issueTokens creates an access token limited to scope and a refresh token
+// in the given family. authTime is the time of the login the family started
+// with and grantedScope the scope granted to it.
+func (h *AuthHandler) issueTokens(c *gin.Context, user *models.User, familyID string, authTime time.Time, grantedScope, scope string) (*TokenResponse, error) {
+	if err := h.DB.LoadUserRoles(user); err != nil {
+		return nil, fmt.Errorf("error getting roles: %w", err)
+	}
+
+	membership, err := h.activeMembership(familyID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, refreshToken, claims, err := h.JWTManager.GenerateTokenPair(user, familyID, scope, membership)
+	if err != nil {
+		return nil, fmt.Errorf("error generating tokens: %w", err)
+	}
+
+	err = h.DB.UpdateSessionActivity(familyID, c.Request.UserAgent(), c.ClientIP(), claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, fmt.Errorf("error updating session: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(h.RefreshTokenTTL)
+	if h.SessionIdleTimeout > 0 && now.Add(h.SessionIdleTimeout).Before(expiresAt) {
+		expiresAt = now.Add(h.SessionIdleTimeout)
+	}
+	if h.SessionMaxAge > 0 && authTime.Add(h.SessionMaxAge).Before(expiresAt) {
+		expiresAt = authTime.Add(h.SessionMaxAge)
+	}
+
+	if _, err := h.DB.CreateRefreshToken(user.ID, refreshToken, familyID, grantedScope, authTime, expiresAt); err != nil {
+		return nil, fmt.Errorf("error saving refresh token: %w", err)
+	}
+
+	return &TokenResponse{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		ExpiresIn:        int64(h.JWTManager.GetTokenTTL().Seconds()),
+		RefreshExpiresIn: int64(expiresAt.Sub(now).Seconds()),
+		Scope:            scope,
+	}, nil
+}
+
+// sessionExpired reports whether the session a refresh token belongs to has
+// run past its absolute or idle limit. A refresh token is issued on every
+// use, so its creation time is when the session was last active.
+func (h *AuthHandler) sessionExpired(refreshToken *database.RefreshToken) bool {
+	now := time.Now()
+	if h.SessionMaxAge > 0 && now.After(refreshToken.AuthTime.Add(h.SessionMaxAge)) {
+		return true
+	}
+	if h.SessionIdleTimeout > 0 && now.After(refreshToken.CreatedAt.Add(h.SessionIdleTimeout)) {
+		return true
+	}
+	return false
+}
+
+// handleRefreshTokenReuse revokes the whole family of a refresh token that
+// was presented after being rotated: either the legitimate client or an
+// attacker holds a stolen copy, and there is no telling which.
+func (h *AuthHandler) handleRefreshTokenReuse(c *gin.Context, refreshToken *database.RefreshToken) {
+	if err := h.endSession(refreshToken.FamilyID, "refresh token reuse"); err != nil {
+		log.Printf("Error revoking refresh token family: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	h.recordSecurityEvent(c, refreshToken.UserID, models.SecurityEventRefreshTokenReuse, "token family "+refreshToken.FamilyID+" revoked")
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+}
+
+func (h *AuthHandler) recordSecurityEvent(c *gin.Context, userID int64, eventType, details string) {
+	event := &models.SecurityEvent{
+		UserID:    userID,
+		Type:      eventType,
+		Details:   details,
+		IPAddress: c.ClientIP(),
+	}
+	log.Printf("Security event %s for user %d from %s: %s", event.Type, event.UserID, event.IPAddress, event.Details)
+
+	if err := h.DB.CreateSecurityEvent(event); err != nil {
+		log.Printf("Error saving security event: %v", err)
+	}
 }
 
 func (h *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+		if !h.authenticate(c) {
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
-			return
+		c.Next()
+	}
+}
+
+// authenticate verifies the bearer token of the request and stores its
+// claims in the context. It aborts the request and reports false if the
+// token is not accepted.
+func (h *AuthHandler) authenticate(c *gin.Context) bool {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+		return false
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+		return false
+	}
+
+	claims, err := h.JWTManager.VerifyToken(parts[1])
+	if err != nil {
+		if errors.Is(err, auth.ErrExpiredToken) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
+			return false
 		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return false
+	}
 
-		claims, err := h.JWTManager.VerifyToken(parts[1])
+	if h.Denylist.IsRevoked(claims.ID) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+		return false
+	}
+
+	if h.EnforceUserStatus {
+		user, err := h.DB.GetUserByID(claims.UserID)
 		if err != nil {
-			if errors.Is(err, auth.ErrExpiredToken) {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
-				return
+			if errors.Is(err, sql.ErrNoRows) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return false
 			}
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
+			log.Printf("Error getting user: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return false
+		}
+		if user.Status != models.UserStatusActive {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": inactiveAccountError(user)})
+			return false
 		}
+	}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
+	c.Set("claims", claims)
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
 
-		c.Next()
-	}
+	return true
 }
 
 func (h *AuthHandler) GetMe(c *gin.Context) {
-	userID, _ := c.Get("user_id")
-	username, _ := c.Get("username")
+	claims := c.MustGet("claims").(*auth.Claims)
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":       userID,
-		"username": username,
-	})
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.DB.LoadUserRoles(user); err != nil {
+		log.Printf("Error getting roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
 }
 
 func (h *AuthHandler) GetPublicKey(c *gin.Context) {
@@ -275,5 +609,20 @@ func (h *AuthHandler) GetPublicKey(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, PublicKeyResponse{Key: publicKeyPEM})
+	c.JSON(http.StatusOK, PublicKeyResponse{
+		Key:   publicKeyPEM,
+		KeyID: h.JWTManager.GetKeyID(),
+	})
+}
+
+func (h *AuthHandler) GetJWKS(c *gin.Context) {
+	jwks, err := h.JWTManager.GetJWKS()
+	if err != nil {
+		log.Printf("Error getting JWKS: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
 } 
\ No newline at end of file
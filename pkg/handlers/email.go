@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+	"github.com/user/user-server/pkg/notify"
+)
+
+type UpdateEmailRequest struct {
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// UpdateEmail sets a new email address for the caller and sends a
+// verification token to it. The address stays unverified until the token is
+// confirmed.
+func (h *AuthHandler) UpdateEmail(c *gin.Context) {
+	var req UpdateEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := auth.CheckPassword(req.Password, user.Password); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid password"})
+		return
+	}
+
+	email := normalizeEmail(req.Email)
+	if email == user.Email {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is unchanged"})
+		return
+	}
+
+	if taken, err := h.emailTaken(email); err != nil {
+		log.Printf("Error checking email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User with this email already exists"})
+		return
+	}
+
+	if err := h.DB.UpdateUserEmail(user.ID, email); err != nil {
+		log.Printf("Error updating email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	user.Email = email
+	user.EmailVerified = false
+
+	if err := h.sendEmailVerification(user); err != nil {
+		log.Printf("Error sending email verification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ResendEmailVerification sends a new verification token for the caller's
+// current email address.
+func (h *AuthHandler) ResendEmailVerification(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No email address set"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	if err := h.sendEmailVerification(user); err != nil {
+		log.Printf("Error sending email verification: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// VerifyEmail confirms a verification token. Tokens are bound to the address
+// they were sent to, so a token for a previous address verifies nothing.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	verificationToken, err := h.DB.GetUserToken(models.UserTokenPurposeEmailVerification, req.Token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+			return
+		}
+		log.Printf("Error getting verification token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if verificationToken.UsedAt != nil || time.Now().After(verificationToken.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	used, err := h.DB.MarkUserTokenUsed(verificationToken.ID)
+	if err != nil {
+		log.Printf("Error marking verification token as used: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !used {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	verified, err := h.DB.MarkUserEmailVerified(verificationToken.UserID, verificationToken.Data)
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !verified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) sendEmailVerification(user *models.User) error {
+	token, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("error generating verification token: %w", err)
+	}
+
+	verificationToken, err := h.DB.CreateUserToken(user.ID, models.UserTokenPurposeEmailVerification, token, user.Email, time.Now().Add(h.EmailVerificationTTL))
+	if err != nil {
+		return fmt.Errorf("error saving verification token: %w", err)
+	}
+
+	link, err := notify.Link(h.EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+
+	return h.Notifier.SendEmailVerification(&notify.EmailVerification{
+		User:      user,
+		Email:     user.Email,
+		Token:     token,
+		Link:      link,
+		ExpiresAt: verificationToken.ExpiresAt,
+	})
+}
+
+func (h *AuthHandler) emailTaken(email string) (bool, error) {
+	_, err := h.DB.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// normalizeEmail lower-cases an address so that uniqueness does not depend
+// on how it was typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
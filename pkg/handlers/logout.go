@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+)
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout ends the caller's session: the refresh token family of the session
+// is deleted and the access token used for the request is denylisted. The
+// session is taken from the refresh token in the body if one is given.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	sessionID := claims.SessionID
+	if req.RefreshToken != "" {
+		refreshToken, err := h.DB.GetRefreshToken(req.RefreshToken)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		// Токен другого пользователя не трогаем, но и не сообщаем об этом
+		if err == nil && refreshToken.UserID == claims.UserID {
+			sessionID = refreshToken.FamilyID
+		}
+	}
+
+	if sessionID != "" {
+		if err := h.endSession(sessionID, "logout"); err != nil {
+			log.Printf("Error ending session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+
+	if err := h.Denylist.Revoke(claims, "logout"); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll ends every session of the caller: all of their refresh tokens
+// are deleted and the latest access token of each session is denylisted
+// together with the one used for the request.
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	if err := h.endAllSessions(claims.UserID, "logout from all sessions"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.Denylist.Revoke(claims, "logout from all sessions"); err != nil {
+		log.Printf("Error revoking access token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+)
+
+const (
+	tokenTypeHintAccessToken  = "access_token"
+	tokenTypeHintRefreshToken = "refresh_token"
+)
+
+// TokenRevocationRequest is accepted as form data, as RFC 7009 requires, or
+// as JSON.
+type TokenRevocationRequest struct {
+	Token         string `form:"token" json:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint"`
+}
+
+type TokenIntrospectionRequest struct {
+	Token         string `form:"token" json:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint"`
+}
+
+// IntrospectionResponse follows RFC 7662. Inactive tokens only carry
+// active=false.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+}
+
+// ServiceAuthMiddleware authenticates backend services with HTTP Basic
+// client credentials.
+func (h *AuthHandler) ServiceAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			c.Header("WWW-Authenticate", `Basic realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+
+		client, err := h.DB.GetServiceClient(clientID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting service client: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		if err != nil || !auth.CheckClientSecret(secret, client.SecretHash) {
+			c.Header("WWW-Authenticate", `Basic realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+
+		c.Set("service_client", client)
+		c.Next()
+	}
+}
+
+// Introspect implements RFC 7662 token introspection for resource servers
+// that cannot verify access tokens themselves. Access tokens are checked for
+// the audience of the calling client when it has one.
+func (h *AuthHandler) Introspect(c *gin.Context) {
+	var req TokenIntrospectionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	client := c.MustGet("service_client").(*models.ServiceClient)
+
+	introspectors := []func(*models.ServiceClient, string) (*IntrospectionResponse, error){
+		h.introspectAccessToken, h.introspectRefreshToken,
+	}
+	if req.TokenTypeHint == tokenTypeHintRefreshToken {
+		introspectors[0], introspectors[1] = introspectors[1], introspectors[0]
+	}
+
+	for _, introspect := range introspectors {
+		resp, err := introspect(client, req.Token)
+		if err != nil {
+			log.Printf("Error introspecting token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		if resp != nil {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, IntrospectionResponse{Active: false})
+}
+
+func (h *AuthHandler) introspectAccessToken(client *models.ServiceClient, token string) (*IntrospectionResponse, error) {
+	var claims *auth.Claims
+	var err error
+	if client.Audience != "" {
+		claims, err = h.JWTManager.VerifyTokenForAudience(token, client.Audience)
+	} else {
+		claims, err = h.JWTManager.VerifyToken(token)
+	}
+	if err != nil || h.Denylist.IsRevoked(claims.ID) {
+		return nil, nil
+	}
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, nil
+	}
+
+	resp := &IntrospectionResponse{
+		Active:    true,
+		Username:  user.Username,
+		Scope:     claims.Scope,
+		TokenType: tokenTypeHintAccessToken,
+		Sub:       strconv.FormatInt(user.ID, 10),
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		resp.Nbf = claims.NotBefore.Unix()
+	}
+
+	return resp, nil
+}
+
+func (h *AuthHandler) introspectRefreshToken(_ *models.ServiceClient, token string) (*IntrospectionResponse, error) {
+	refreshToken, err := h.DB.GetRefreshToken(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if refreshToken.RotatedAt != nil || time.Now().After(refreshToken.ExpiresAt) {
+		return nil, nil
+	}
+
+	user, err := h.DB.GetUserByID(refreshToken.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, nil
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		Username:  user.Username,
+		Scope:     refreshToken.Scope,
+		TokenType: tokenTypeHintRefreshToken,
+		Exp:       refreshToken.ExpiresAt.Unix(),
+		Iat:       refreshToken.CreatedAt.Unix(),
+		Sub:       strconv.FormatInt(user.ID, 10),
+	}, nil
+}
+
+// RevokeToken implements RFC 7009 token revocation. Refresh tokens are
+// deleted together with their token family and access tokens are
+// denylisted. Unknown, invalid and expired
+// tokens are reported as revoked too, so the response never tells whether a
+// token existed.
+func (h *AuthHandler) RevokeToken(c *gin.Context) {
+	var req TokenRevocationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	revokers := []func(string) (bool, error){h.revokeRefreshToken, h.revokeAccessToken}
+	if req.TokenTypeHint == tokenTypeHintAccessToken {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+
+	for _, revoke := range revokers {
+		revoked, err := revoke(req.Token)
+		if err != nil {
+			log.Printf("Error revoking token: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+			return
+		}
+		if revoked {
+			break
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *AuthHandler) revokeRefreshToken(token string) (bool, error) {
+	refreshToken, err := h.DB.GetRefreshToken(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// Revoking a refresh token ends the whole login it was issued for.
+	if err := h.endSession(refreshToken.FamilyID, "revoked by client"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *AuthHandler) revokeAccessToken(token string) (bool, error) {
+	claims, err := h.JWTManager.VerifyToken(token)
+	if err != nil {
+		return false, nil
+	}
+
+	if err := h.Denylist.Revoke(claims, "revoked by client"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/database"
+	"github.com/user/user-server/pkg/models"
+)
+
+// orgSlugPattern matches valid organization slugs.
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+type CreateOrganizationRequest struct {
+	Slug string `json:"slug" binding:"required"`
+	Name string `json:"name" binding:"required,max=128"`
+}
+
+type SwitchOrgRequest struct {
+	RefreshRequest
+	// OrgID is the organization to switch to, 0 to leave the session
+	// without one.
+	OrgID *int64 `json:"org_id" binding:"required"`
+}
+
+type OrgMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+type OrgInviteRequest struct {
+	Role string `json:"role" binding:"omitempty,oneof=owner admin member"`
+}
+
+type JoinOrganizationRequest struct {
+	InviteToken string `json:"invite_token" binding:"required"`
+}
+
+// CreateOrganization creates an organization owned by the current user.
+func (h *AuthHandler) CreateOrganization(c *gin.Context) {
+	var req CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	name, ok := validateDisplayName(req.Name)
+	if !ok || name == "" || !orgSlugPattern.MatchString(req.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization name or slug"})
+		return
+	}
+
+	if taken, err := h.DB.OrganizationSlugTaken(req.Slug); err != nil {
+		log.Printf("Error checking organization slug: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Organization slug already taken"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	org := &models.Organization{Slug: req.Slug, Name: name}
+	if err := h.DB.CreateOrganization(org, claims.UserID); err != nil {
+		log.Printf("Error creating organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// ListMyOrganizations returns the organizations of the current user with
+// their role in each.
+func (h *AuthHandler) ListMyOrganizations(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	memberships, err := h.DB.ListUserOrganizations(claims.UserID)
+	if err != nil {
+		log.Printf("Error listing organizations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": memberships})
+}
+
+// SwitchOrg makes another organization active in the session of the given
+// refresh token and issues new tokens for it, like Refresh.
+func (h *AuthHandler) SwitchOrg(c *gin.Context) {
+	var req SwitchOrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil || *req.OrgID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	h.refresh(c, &req.RefreshRequest, req.OrgID)
+}
+
+// ListOrgMembers returns the members of an organization the current user
+// belongs to.
+func (h *AuthHandler) ListOrgMembers(c *gin.Context) {
+	membership, ok := h.currentMembership(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.DB.ListOrgMembers(membership.OrgID)
+	if err != nil {
+		log.Printf("Error listing members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// SetOrgMemberRole changes the role of a member. Admins manage admins and
+// members, only owners manage owners.
+func (h *AuthHandler) SetOrgMemberRole(c *gin.Context) {
+	var req OrgMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	membership, target, ok := h.managedMember(c)
+	if !ok {
+		return
+	}
+
+	if !canManageOrgRole(membership.Role, req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient organization role"})
+		return
+	}
+	if _, err := h.DB.SetOrgMemberRole(target.OrgID, target.UserID, req.Role); err != nil {
+		if errors.Is(err, database.ErrLastOrgOwner) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Organization must keep an owner"})
+			return
+		}
+		log.Printf("Error updating member role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if req.Role != target.Role {
+		sessions, err := h.DB.ListOrgSessions(target.OrgID, target.UserID)
+		if err != nil {
+			log.Printf("Error listing sessions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		h.revokeOrgAccessTokens(sessions, "organization role changed")
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveOrgMember takes a member out of an organization. Members can always
+// remove themselves, that is leave.
+func (h *AuthHandler) RemoveOrgMember(c *gin.Context) {
+	membership, target, ok := h.managedMember(c)
+	if !ok {
+		return
+	}
+
+	// Сессии нужно найти до удаления, оно сбрасывает в них организацию
+	sessions, err := h.DB.ListOrgSessions(target.OrgID, target.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if _, err := h.DB.RemoveOrgMember(target.OrgID, target.UserID); err != nil {
+		if errors.Is(err, database.ErrLastOrgOwner) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Organization must keep an owner"})
+			return
+		}
+		log.Printf("Error removing member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	h.revokeOrgAccessTokens(sessions, "removed from organization")
+
+	log.Printf("User %d removed from organization %d by user %d", target.UserID, target.OrgID, membership.UserID)
+	c.Status(http.StatusNoContent)
+}
+
+// CreateOrgInvite creates an invite token into an organization, for existing
+// users to join with. It cannot be used to register.
+func (h *AuthHandler) CreateOrgInvite(c *gin.Context) {
+	var req OrgInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+
+	membership, ok := h.currentMembership(c)
+	if !ok {
+		return
+	}
+	if !canManageOrgRole(membership.Role, req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient organization role"})
+		return
+	}
+
+	token, err := auth.GenerateInviteToken()
+	if err != nil {
+		log.Printf("Error generating invite token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	inviteToken, err := h.DB.CreateOrgInviteToken(token, membership.OrgID, req.Role)
+	if err != nil {
+		log.Printf("Error saving invite token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, inviteToken)
+}
+
+// JoinOrganization makes the current user a member of the organization an
+// invite token is for.
+func (h *AuthHandler) JoinOrganization(c *gin.Context) {
+	var req JoinOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	inviteToken, err := h.DB.GetInviteToken(req.InviteToken)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite token"})
+			return
+		}
+		log.Printf("Error getting invite token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if inviteToken.OrgID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite token"})
+		return
+	}
+	if inviteToken.Used {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invite token already used"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	added, err := h.DB.AcceptOrgInvite(inviteToken, claims.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrInviteTokenUsed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invite token already used"})
+			return
+		}
+		log.Printf("Error adding member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !added {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already a member of this organization"})
+		return
+	}
+
+	membership, err := h.DB.GetOrgMembership(inviteToken.OrgID, claims.UserID)
+	if err != nil {
+		log.Printf("Error getting membership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, membership)
+}
+
+// revokeOrgAccessTokens denylists the latest access token of each session,
+// since it still carries the member's former organization role. The
+// sessions stay open and pick up the change on the next refresh.
+func (h *AuthHandler) revokeOrgAccessTokens(sessions []models.Session, reason string) {
+	for i := range sessions {
+		if err := h.revokeSessionAccessToken(&sessions[i], reason); err != nil {
+			log.Printf("Error revoking session access token: %v", err)
+		}
+	}
+}
+
+// activeMembership returns the membership of the user in the organization
+// active in a session, or nil if there is none. A session whose user has
+// left the organization since is switched back to none.
+func (h *AuthHandler) activeMembership(sessionID string, userID int64) (*models.OrgMembership, error) {
+	session, err := h.DB.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	if session.OrgID == 0 {
+		return nil, nil
+	}
+
+	membership, err := h.DB.GetOrgMembership(session.OrgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := h.DB.SetSessionOrg(sessionID, 0); err != nil {
+			return nil, fmt.Errorf("error switching organization: %w", err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting membership: %w", err)
+	}
+	return membership, nil
+}
+
+// currentMembership loads the membership of the current user in the
+// organization named by the :id path parameter. It writes the error
+// response and reports false if the user is not a member; other
+// organizations look as if they did not exist.
+func (h *AuthHandler) currentMembership(c *gin.Context) (*models.OrgMembership, bool) {
+	orgID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return nil, false
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	membership, err := h.DB.GetOrgMembership(orgID, claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return nil, false
+		}
+		log.Printf("Error getting membership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	return membership, true
+}
+
+// managedMember loads the membership of the current user and that of the
+// member named by the :user_id path parameter, and checks that the current
+// user may manage that member. It writes the error response and reports
+// false otherwise.
+func (h *AuthHandler) managedMember(c *gin.Context) (*models.OrgMembership, *models.OrgMembership, bool) {
+	membership, ok := h.currentMembership(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, nil, false
+	}
+
+	target := membership
+	if userID != membership.UserID {
+		target, err = h.DB.GetOrgMembership(membership.OrgID, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+				return nil, nil, false
+			}
+			log.Printf("Error getting membership: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return nil, nil, false
+		}
+	}
+
+	// Выйти из организации или понизить себя может любой участник
+	if target != membership && !canManageOrgRole(membership.Role, target.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient organization role"})
+		return nil, nil, false
+	}
+	return membership, target, true
+}
+
+// canManageOrgRole reports whether a member with role actorRole may manage
+// members with, or hand out, role.
+func canManageOrgRole(actorRole, role string) bool {
+	switch actorRole {
+	case models.OrgRoleOwner:
+		return true
+	case models.OrgRoleAdmin:
+		return role != models.OrgRoleOwner
+	default:
+		return false
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+	// RevokeOtherSessions ends every session except the current one.
+	RevokeOtherSessions bool `json:"revoke_other_sessions"`
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := auth.CheckPassword(req.CurrentPassword, user.Password); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid current password"})
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current password"})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.DB.UpdateUserPassword(user.ID, hashedPassword); err != nil {
+		log.Printf("Error updating password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if req.RevokeOtherSessions {
+		sessions, err := h.DB.ListUserSessions(user.ID)
+		if err != nil {
+			log.Printf("Error listing sessions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		for _, session := range sessions {
+			if session.ID == claims.SessionID {
+				continue
+			}
+			if err := h.endSession(session.ID, "password changed"); err != nil {
+				log.Printf("Error ending session: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
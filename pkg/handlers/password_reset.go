@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+	"github.com/user/user-server/pkg/notify"
+)
+
+// PasswordResetRequest names the account by username or by verified email
+// address.
+type PasswordResetRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// RequestPasswordReset sends a reset token to the user. The response is the
+// same whether or not the user exists, so it cannot be used to probe for
+// usernames.
+func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Username == "") == (req.Email == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	var user *models.User
+	var err error
+	if req.Username != "" {
+		user, err = h.DB.GetUserByUsername(req.Username)
+	} else {
+		user, err = h.DB.GetUserByEmail(normalizeEmail(req.Email))
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// По неподтверждённому адресу и заблокированным аккаунтам токены не
+	// отправляем. Ошибку отправки только логируем, иначе по ответу видно,
+	// что аккаунт существует
+	if err == nil && (req.Email == "" || user.EmailVerified) && accountBlocked(user) == "" {
+		if err := h.sendPasswordReset(user); err != nil {
+			log.Printf("Error sending password reset: %v", err)
+		}
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ConfirmPasswordReset sets a new password using a reset token and ends all
+// sessions of the user.
+func (h *AuthHandler) ConfirmPasswordReset(c *gin.Context) {
+	var req PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	resetToken, err := h.DB.GetUserToken(models.UserTokenPurposePasswordReset, req.Token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		log.Printf("Error getting reset token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	used, err := h.DB.MarkUserTokenUsed(resetToken.ID)
+	if err != nil {
+		log.Printf("Error marking reset token as used: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !used {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if err := h.DB.UpdateUserPassword(resetToken.UserID, hashedPassword); err != nil {
+		log.Printf("Error updating password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// Кто-то мог войти со старым паролем, поэтому завершаем все сессии
+	if err := h.endAllSessions(resetToken.UserID, "password reset"); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) sendPasswordReset(user *models.User) error {
+	token, err := auth.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("error generating reset token: %w", err)
+	}
+
+	resetToken, err := h.DB.CreateUserToken(user.ID, models.UserTokenPurposePasswordReset, token, "", time.Now().Add(h.PasswordResetTTL))
+	if err != nil {
+		return fmt.Errorf("error saving reset token: %w", err)
+	}
+
+	link, err := notify.Link(h.PasswordResetURL, token)
+	if err != nil {
+		return err
+	}
+
+	return h.Notifier.SendPasswordReset(&notify.PasswordReset{
+		User:      user,
+		Token:     token,
+		Link:      link,
+		ExpiresAt: resetToken.ExpiresAt,
+	})
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+	"golang.org/x/text/language"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAvatarURLLength   = 2048
+)
+
+// UpdateProfileRequest holds the profile fields to change. Omitted fields
+// are left as they are, empty strings clear them.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Timezone    *string `json:"timezone"`
+}
+
+func (h *AuthHandler) UpdateProfile(c *gin.Context) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	user, err := h.DB.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if msg := req.apply(user); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.DB.UpdateUserProfile(user); err != nil {
+		log.Printf("Error updating profile: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// apply validates the requested changes and applies them to user. It
+// returns the error to report if a field is invalid.
+func (r *UpdateProfileRequest) apply(user *models.User) string {
+	if r.DisplayName != nil {
+		displayName, ok := validateDisplayName(*r.DisplayName)
+		if !ok {
+			return "Invalid display name"
+		}
+		user.DisplayName = displayName
+	}
+
+	if r.AvatarURL != nil {
+		avatarURL, ok := validateAvatarURL(*r.AvatarURL)
+		if !ok {
+			return "Invalid avatar URL"
+		}
+		user.AvatarURL = avatarURL
+	}
+
+	if r.Locale != nil {
+		locale, ok := validateLocale(*r.Locale)
+		if !ok {
+			return "Invalid locale"
+		}
+		user.Locale = locale
+	}
+
+	if r.Timezone != nil {
+		timezone, ok := validateTimezone(*r.Timezone)
+		if !ok {
+			return "Invalid timezone"
+		}
+		user.Timezone = timezone
+	}
+	return ""
+}
+
+func validateDisplayName(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > maxDisplayNameLength {
+		return "", false
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// validateAvatarURL accepts absolute http and https URLs.
+func validateAvatarURL(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", true
+	}
+	if len(value) > maxAvatarURLLength {
+		return "", false
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// validateLocale accepts a BCP 47 language tag and returns it in canonical
+// form.
+func validateLocale(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", true
+	}
+
+	tag, err := language.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	return tag.String(), true
+}
+
+// validateTimezone accepts an IANA time zone name.
+func validateTimezone(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", true
+	}
+
+	// LoadLocation also accepts "Local", which means nothing to clients.
+	if value == "Local" {
+		return "", false
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return "", false
+	}
+	return location.String(), true
+}
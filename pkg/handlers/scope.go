@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+)
+
+// grantScope checks the scope a client asked for against the scope it may
+// have, where an empty allowed scope means any configured scope. Without a
+// request the client gets all it may have. It reports false if the request
+// asks for more.
+func (h *AuthHandler) grantScope(requested, allowed string) (string, bool) {
+	limit := h.Scopes
+	if allowed != "" {
+		limit, _ = auth.ParseScope(allowed)
+	}
+
+	if requested == "" {
+		return auth.FormatScope(limit), true
+	}
+
+	tokens, ok := auth.ParseScope(requested)
+	if !ok || len(tokens) == 0 {
+		return "", false
+	}
+	// Без настроенного списка клиент может сузить доступ до любых скоупов
+	if len(limit) > 0 {
+		for _, token := range tokens {
+			if !slices.Contains(limit, token) {
+				return "", false
+			}
+		}
+	}
+	return auth.FormatScope(tokens), true
+}
+
+// RequireScope only lets through requests whose access token grants all of
+// scopes. Tokens without a scope claim grant every scope. It must run after
+// AuthMiddleware.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(*auth.Claims)
+		for _, scope := range scopes {
+			if !claims.HasScope(scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient scope"})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+	"github.com/user/user-server/pkg/models"
+)
+
+type SessionResponse struct {
+	models.Session
+	// Current marks the session the request was made from.
+	Current bool `json:"current"`
+}
+
+// ListSessions returns the sessions the caller is logged in with.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	sessions, err := h.DB.ListUserSessions(claims.UserID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, SessionResponse{
+			Session: session,
+			Current: session.ID == claims.SessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+// RevokeSession ends one of the caller's sessions.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	session, err := h.DB.GetSession(c.Param("id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if err != nil || session.UserID != claims.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.endSession(session.ID, "session revoked by user"); err != nil {
+		log.Printf("Error revoking session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// endSession deletes a session with its refresh token family and denylists
+// the latest access token issued in it.
+func (h *AuthHandler) endSession(sessionID, reason string) error {
+	session, err := h.DB.GetSession(sessionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error getting session: %w", err)
+	}
+
+	if err := h.DB.DeleteRefreshTokenFamily(sessionID); err != nil {
+		return fmt.Errorf("error deleting refresh token family: %w", err)
+	}
+
+	if session != nil {
+		return h.revokeSessionAccessToken(session, reason)
+	}
+	return nil
+}
+
+// endAllSessions deletes every refresh token of a user and denylists the
+// latest access token of each session.
+func (h *AuthHandler) endAllSessions(userID int64, reason string) error {
+	sessions, err := h.DB.ListUserSessions(userID)
+	if err != nil {
+		return fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	if err := h.DB.DeleteUserRefreshTokens(userID); err != nil {
+		return fmt.Errorf("error deleting refresh tokens: %w", err)
+	}
+
+	for i := range sessions {
+		if err := h.revokeSessionAccessToken(&sessions[i], reason); err != nil {
+			log.Printf("Error revoking session access token: %v", err)
+		}
+	}
+	return nil
+}
+
+func (h *AuthHandler) revokeSessionAccessToken(session *models.Session, reason string) error {
+	if session.AccessTokenID == "" {
+		return nil
+	}
+
+	if err := h.Denylist.RevokeID(session.AccessTokenID, session.UserID, session.AccessExpiresAt, reason); err != nil {
+		return fmt.Errorf("error revoking access token: %w", err)
+	}
+	return nil
+}
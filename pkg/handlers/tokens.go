@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/user-server/pkg/auth"
+)
+
+type RevokeOwnTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// RevokeOwnToken revokes an access token of the caller, or the token used
+// for the request when none is given.
+func (h *AuthHandler) RevokeOwnToken(c *gin.Context) {
+	var req RevokeOwnTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	claims := c.MustGet("claims").(*auth.Claims)
+	if req.Token != "" {
+		tokenClaims, err := h.JWTManager.VerifyToken(req.Token)
+		if err != nil || tokenClaims.UserID != claims.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token"})
+			return
+		}
+		claims = tokenClaims
+	}
+
+	if err := h.Denylist.Revoke(claims, "revoked by user"); err != nil {
+		log.Printf("Error revoking token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jti": claims.ID, "revoked": true})
+}
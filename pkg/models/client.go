@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// ServiceClient is a backend service allowed to call the introspection
+// endpoint.
+type ServiceClient struct {
+	ID         int64     `json:"id"`
+	ClientID   string    `json:"client_id"`
+	SecretHash string    `json:"-"`
+	Name       string    `json:"name"`
+	Audience   string    `json:"audience,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+const (
+	// SecurityEventRefreshTokenReuse is recorded when an already rotated
+	// refresh token is presented again.
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+type SecurityEvent struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Type      string    `json:"type"`
+	Details   string    `json:"details,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
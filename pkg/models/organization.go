@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	// OrgRoleOwner can do everything in an organization, including managing
+	// other owners.
+	OrgRoleOwner = "owner"
+	// OrgRoleAdmin manages members and invites, except owners.
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrgMembership links a user to an organization with a role in it.
+type OrgMembership struct {
+	OrgID  int64  `json:"org_id"`
+	UserID int64  `json:"user_id"`
+	Role   string `json:"role"`
+	// Username is only filled in for member lists.
+	Username string `json:"username,omitempty"`
+	// Organization is only filled in for the organizations of a user.
+	Organization *Organization `json:"organization,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+// RoleAdmin grants access to the admin API. It always exists and cannot be
+// deleted.
+const RoleAdmin = "admin"
+
+// RoleNamePattern matches valid role and permission names.
+var RoleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,63}$`)
+
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+	Users       int       `json:"users"`
+	CreatedAt   time.Time `json:"created_at"`
+}
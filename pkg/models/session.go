@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session describes a login on one device. It shares its ID with the refresh
+// token family the login started.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"-"`
+	DeviceName string    `json:"device_name,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// OrgID is the organization active in the session, 0 if none.
+	OrgID int64 `json:"org_id,omitempty"`
+	// AccessTokenID is the jti of the latest access token issued in the
+	// session, so that ending the session can revoke it as well.
+	AccessTokenID   string    `json:"-"`
+	AccessExpiresAt time.Time `json:"-"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type RevokedToken struct {
+	TokenID   string    `json:"jti"`
+	UserID    int64     `json:"user_id"`
+	Reason    string    `json:"reason,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
@@ -2,12 +2,40 @@ package models
 
 import "time"
 
+const (
+	UserStatusActive = "active"
+	// UserStatusDisabled marks an account switched off by an operator.
+	UserStatusDisabled = "disabled"
+	// UserStatusLocked marks an account blocked for security reasons.
+	UserStatusLocked = "locked"
+	// UserStatusPendingDeletion marks an account scheduled for deletion.
+	UserStatusPendingDeletion = "pending_deletion"
+)
+
 type User struct {
-	ID        int64     `json:"id"`
-	Username  string    `json:"username"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"-"`
+	// Email is empty for users who have not set one.
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	DisplayName   string `json:"display_name,omitempty"`
+	AvatarURL     string `json:"avatar_url,omitempty"`
+	// Locale is a BCP 47 language tag, e.g. "en-US".
+	Locale string `json:"locale,omitempty"`
+	// Timezone is an IANA time zone name, e.g. "Europe/Berlin".
+	Timezone string `json:"timezone,omitempty"`
+	Status   string `json:"status"`
+	// StatusReason records why the account was disabled or locked.
+	StatusReason string `json:"status_reason,omitempty"`
+	// Roles and Permissions are only filled in where they are needed, see
+	// DB.LoadUserRoles.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// DeleteAfter is set while the account is scheduled for deletion.
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 type InviteToken struct {
@@ -16,4 +44,7 @@ type InviteToken struct {
 	Used      bool      `json:"used"`
 	CreatedAt time.Time `json:"created_at"`
 	UsedAt    *time.Time `json:"used_at,omitempty"`
+	// OrgID and OrgRole are set for invites into an organization.
+	OrgID   int64  `json:"org_id,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
 } 
\ No newline at end of file
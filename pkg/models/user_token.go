@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	UserTokenPurposePasswordReset     = "password_reset"
+	UserTokenPurposeEmailVerification = "email_verification"
+)
+
+// UserToken is a single-use token sent to a user out of band, e.g. in a
+// password reset link. Only a keyed hash of the token is stored.
+type UserToken struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Purpose   string `json:"purpose"`
+	TokenHash string `json:"-"`
+	// Data binds the token to a value, e.g. the email address being
+	// verified.
+	Data      string     `json:"data,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileNotifier appends messages to a file as JSON lines, so that a local
+// setup or a separate delivery process can pick them up.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+type fileMessage struct {
+	Type      string    `json:"type"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email,omitempty"`
+	Token     string    `json:"token"`
+	Link      string    `json:"link,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if path == "" {
+		return nil, fmt.Errorf("notification file path is required")
+	}
+	return &FileNotifier{path: path}, nil
+}
+
+func (n *FileNotifier) SendPasswordReset(msg *PasswordReset) error {
+	return n.write(fileMessage{
+		Type:      "password_reset",
+		UserID:    msg.User.ID,
+		Username:  msg.User.Username,
+		Email:     msg.User.Email,
+		Token:     msg.Token,
+		Link:      msg.Link,
+		ExpiresAt: msg.ExpiresAt,
+	})
+}
+
+func (n *FileNotifier) SendEmailVerification(msg *EmailVerification) error {
+	return n.write(fileMessage{
+		Type:      "email_verification",
+		UserID:    msg.User.ID,
+		Username:  msg.User.Username,
+		Email:     msg.Email,
+		Token:     msg.Token,
+		Link:      msg.Link,
+		ExpiresAt: msg.ExpiresAt,
+	})
+}
+
+func (n *FileNotifier) write(msg fileMessage) error {
+	msg.CreatedAt = time.Now()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("notification marshal error: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("notification file open error: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("notification file write error: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"log"
+	"time"
+)
+
+// LogNotifier writes messages, tokens included, to the server log. It is
+// meant for local development only.
+type LogNotifier struct{}
+
+func (n *LogNotifier) SendPasswordReset(msg *PasswordReset) error {
+	log.Printf("Password reset for user %s (expires %s): token %s %s",
+		msg.User.Username, msg.ExpiresAt.Format(time.RFC3339), msg.Token, msg.Link)
+	return nil
+}
+
+func (n *LogNotifier) SendEmailVerification(msg *EmailVerification) error {
+	log.Printf("Email verification for user %s <%s> (expires %s): token %s %s",
+		msg.User.Username, msg.Email, msg.ExpiresAt.Format(time.RFC3339), msg.Token, msg.Link)
+	return nil
+}
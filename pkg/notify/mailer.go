@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+const (
+	// mailBatchSize limits how many messages one delivery run sends.
+	mailBatchSize = 50
+	// mailSendTimeout is how long a claimed message is hidden from other
+	// server instances while it is being sent.
+	mailSendTimeout = 5 * time.Minute
+	maxRetryDelay   = time.Hour
+)
+
+// MailOutbox persists outgoing mail until it is delivered.
+type MailOutbox interface {
+	EnqueueMail(mail *models.OutboxMail) error
+	GetDueMail(now time.Time, limit int) ([]models.OutboxMail, error)
+	ClaimMail(id int64, now, until time.Time) (bool, error)
+	MarkMailSent(id int64) error
+	MarkMailFailed(id int64, lastError string, nextAttemptAt *time.Time) error
+}
+
+// Mailer is a Notifier that delivers messages by email. Messages are
+// rendered and stored in the outbox first, and Run sends them in the
+// background, retrying failed attempts with exponential backoff, so mail
+// survives restarts and SMTP outages.
+type Mailer struct {
+	outbox      MailOutbox
+	sender      Sender
+	templates   *Templates
+	maxAttempts int
+	wake        chan struct{}
+}
+
+func NewMailer(outbox MailOutbox, sender Sender, maxAttempts int) (*Mailer, error) {
+	templates, err := LoadTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &Mailer{
+		outbox:      outbox,
+		sender:      sender,
+		templates:   templates,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+	}, nil
+}
+
+// SendPasswordReset mails the reset token to the user's email address. Users
+// without a verified address are skipped: the token must not go to an
+// address nobody has confirmed.
+func (m *Mailer) SendPasswordReset(msg *PasswordReset) error {
+	if msg.User.Email == "" || !msg.User.EmailVerified {
+		log.Printf("Password reset for user %s not sent: no verified email address", msg.User.Username)
+		return nil
+	}
+
+	return m.enqueue("password_reset", msg.User.Email, templateData{
+		Username:  msg.User.Username,
+		Email:     msg.User.Email,
+		Token:     msg.Token,
+		Link:      msg.Link,
+		ExpiresAt: msg.ExpiresAt,
+	})
+}
+
+func (m *Mailer) SendEmailVerification(msg *EmailVerification) error {
+	return m.enqueue("email_verification", msg.Email, templateData{
+		Username:  msg.User.Username,
+		Email:     msg.Email,
+		Token:     msg.Token,
+		Link:      msg.Link,
+		ExpiresAt: msg.ExpiresAt,
+	})
+}
+
+func (m *Mailer) enqueue(kind, recipient string, data templateData) error {
+	msg, err := m.templates.Render(kind, recipient, data)
+	if err != nil {
+		return err
+	}
+
+	err = m.outbox.EnqueueMail(&models.OutboxMail{
+		Recipient: msg.To,
+		Subject:   msg.Subject,
+		TextBody:  msg.Text,
+		HTMLBody:  msg.HTML,
+	})
+	if err != nil {
+		return fmt.Errorf("error queueing mail: %w", err)
+	}
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued mail every interval, and right away when new mail is
+// queued. It never returns.
+func (m *Mailer) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.DeliverDue()
+
+		select {
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// DeliverDue sends the queued mail whose next attempt is due.
+func (m *Mailer) DeliverDue() {
+	now := time.Now()
+	mails, err := m.outbox.GetDueMail(now, mailBatchSize)
+	if err != nil {
+		log.Printf("Error loading queued mail: %v", err)
+		return
+	}
+
+	for _, mail := range mails {
+		claimed, err := m.outbox.ClaimMail(mail.ID, now, now.Add(mailSendTimeout))
+		if err != nil {
+			log.Printf("Error claiming mail %d: %v", mail.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		m.deliver(&mail)
+	}
+}
+
+func (m *Mailer) deliver(mail *models.OutboxMail) {
+	err := m.sender.Send(&Message{
+		To:      mail.Recipient,
+		Subject: mail.Subject,
+		Text:    mail.TextBody,
+		HTML:    mail.HTMLBody,
+	})
+	if err == nil {
+		if err := m.outbox.MarkMailSent(mail.ID); err != nil {
+			log.Printf("Error marking mail %d as sent: %v", mail.ID, err)
+		}
+		return
+	}
+
+	attempts := mail.Attempts + 1
+	var nextAttemptAt *time.Time
+	if attempts < m.maxAttempts {
+		next := time.Now().Add(retryDelay(attempts))
+		nextAttemptAt = &next
+		log.Printf("Error sending mail %d to %s (attempt %d of %d, retrying at %s): %v",
+			mail.ID, mail.Recipient, attempts, m.maxAttempts, next.Format(time.RFC3339), err)
+	} else {
+		log.Printf("Error sending mail %d to %s, giving up after %d attempts: %v", mail.ID, mail.Recipient, attempts, err)
+	}
+
+	if err := m.outbox.MarkMailFailed(mail.ID, err.Error(), nextAttemptAt); err != nil {
+		log.Printf("Error recording failed mail %d: %v", mail.ID, err)
+	}
+}
+
+// retryDelay doubles the wait after every failed attempt, starting at one
+// minute.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
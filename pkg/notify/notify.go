@@ -0,0 +1,74 @@
+// Package notify delivers messages such as password reset and email
+// verification links to users.
+package notify
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/user/user-server/pkg/models"
+)
+
+// Notifier delivers messages to users. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	SendPasswordReset(msg *PasswordReset) error
+	SendEmailVerification(msg *EmailVerification) error
+}
+
+// PasswordReset carries a password reset token to the user it was issued
+// for.
+type PasswordReset struct {
+	User  *models.User
+	Token string
+	// Link is the reset page URL with the token, empty when no reset URL
+	// is configured.
+	Link      string
+	ExpiresAt time.Time
+}
+
+// EmailVerification carries a verification token to the email address it
+// was issued for.
+type EmailVerification struct {
+	User *models.User
+	// Email is the address being verified.
+	Email string
+	Token string
+	// Link is the verification page URL with the token, empty when no
+	// verification URL is configured.
+	Link      string
+	ExpiresAt time.Time
+}
+
+// New returns the notifier of the given kind: "log" writes messages to the
+// server log, "file" appends them to path. Email delivery needs an outbox
+// and is set up with NewMailer instead.
+func New(kind, path string) (Notifier, error) {
+	switch kind {
+	case "log":
+		return &LogNotifier{}, nil
+	case "file":
+		return NewFileNotifier(path)
+	default:
+		return nil, fmt.Errorf("unknown notifier %q, expected log or file", kind)
+	}
+}
+
+// Link appends token as the token query parameter of baseURL. It returns an
+// empty string if baseURL is empty.
+func Link(baseURL, token string) (string, error) {
+	if baseURL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid link URL: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
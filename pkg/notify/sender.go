@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Sender delivers a single email.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// NewSender returns the mail sender of the given backend: "smtp" or "file".
+func NewSender(backend string, config MailConfig) (Sender, error) {
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+
+	switch backend {
+	case "smtp":
+		if config.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP host is required")
+		}
+		return &SMTPSender{config: config}, nil
+	case "file":
+		if config.Dir == "" {
+			return nil, fmt.Errorf("mail directory is required")
+		}
+		return &FileSender{dir: config.Dir, from: config.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q, expected smtp or file", backend)
+	}
+}
+
+type MailConfig struct {
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// Dir is where the file backend writes messages.
+	Dir string
+}
+
+// SMTPSender sends mail through an SMTP server, upgrading the connection
+// with STARTTLS when the server supports it.
+type SMTPSender struct {
+	config MailConfig
+}
+
+func (s *SMTPSender) Send(msg *Message) error {
+	data, err := msg.Build(s.config.From)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	}
+
+	from, _ := mail.ParseAddress(s.config.From)
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	addr := net.JoinHostPort(s.config.SMTPHost, strconv.Itoa(s.config.SMTPPort))
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, data)
+}
+
+// FileSender writes each message as an .eml file into the new subdirectory
+// of a maildir-like directory, for development and tests.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func (s *FileSender) Send(msg *Message) error {
+	data, err := msg.Build(s.from)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.dir, "new")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	// Пишем во временный файл, чтобы читатель каталога не увидел
+	// недописанное письмо
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.%s.eml", time.Now().UnixNano(), filepath.Base(tmp.Name())[len(".tmp-"):])
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Templates render emails from a plain text and an HTML template per
+// message kind, e.g. templates/password_reset.txt and
+// templates/password_reset.html.
+type Templates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+type templateData struct {
+	Username  string
+	Email     string
+	Token     string
+	Link      string
+	ExpiresAt time.Time
+}
+
+var subjects = map[string]string{
+	"password_reset":     "Reset your password",
+	"email_verification": "Verify your email address",
+}
+
+func LoadTemplates() (*Templates, error) {
+	text, err := texttemplate.ParseFS(templateFiles, "templates/*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("text template parse error: %w", err)
+	}
+
+	html, err := htmltemplate.ParseFS(templateFiles, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("HTML template parse error: %w", err)
+	}
+
+	return &Templates{text: text, html: html}, nil
+}
+
+// Render renders the message of the given kind for recipient.
+func (t *Templates) Render(kind, recipient string, data templateData) (*Message, error) {
+	var text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&text, kind+".txt", data); err != nil {
+		return nil, fmt.Errorf("text template %s error: %w", kind, err)
+	}
+	if err := t.html.ExecuteTemplate(&html, kind+".html", data); err != nil {
+		return nil, fmt.Errorf("HTML template %s error: %w", kind, err)
+	}
+
+	return &Message{
+		To:      recipient,
+		Subject: subjects[kind],
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}